@@ -0,0 +1,78 @@
+// Command dedupe reports rows that name the same place and, with --merge,
+// merges them straight in the database. A running server does not see those
+// merges and keeps answering from its cache, restart it afterwards or merge
+// through POST /api/admin/locations/duplicates/merge instead.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/dedupe"
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+func main() {
+	country := flag.String("country", "", "only look at this country, any code or name")
+	threshold := flag.Float64("threshold", 10, "max distance in km between two rows of a cluster")
+	merge := flag.Bool("merge", false, "merge every cluster into its kept row instead of only reporting, restart a running server afterwards")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *country != "" {
+		code, ok := iso3166.NormalizeCountry(*country)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown country %q\n", *country)
+			os.Exit(2)
+		}
+		*country = code
+	}
+
+	// InitDB may re-clean stored names, it must use the server's romanization
+	romanization, err := util.ParseRomanization(os.Getenv("ROMANIZE_CYRILLIC"), os.Getenv("ROMANIZE_GREEK"))
+	if err != nil {
+		slog.Error("invalid romanization", "error", err)
+		os.Exit(2)
+	}
+	util.SetRomanization(romanization)
+
+	db := database.InitDB()
+	defer db.Pool.Close()
+
+	clusters, err := dedupe.Find(ctx, db, *country, *threshold)
+	if err != nil {
+		slog.Error("could not search for duplicates", "error", err)
+		os.Exit(1)
+	}
+
+	for _, c := range clusters {
+		fmt.Printf("keep #%d %s, %s, %s (%.4f, %.4f)\n", c.Keep.ID, c.Keep.CityName, c.Keep.State, c.Keep.Country, c.Keep.Lat, c.Keep.Lon)
+		for _, d := range c.Duplicates {
+			fmt.Printf("  dup #%d %s, %s, %s (%.4f, %.4f)\n", d.ID, d.CityName, d.State, d.Country, d.Lat, d.Lon)
+		}
+	}
+	slog.Info("duplicate search finished", "clusters", len(clusters))
+
+	if !*merge || len(clusters) == 0 {
+		return
+	}
+
+	removed, err := dedupe.MergeAll(ctx, clusters, func(ctx context.Context, targetID int64, sourceIDs []int64) error {
+		_, err := db.MergeLocations(ctx, targetID, sourceIDs)
+		return err
+	})
+	if err != nil {
+		slog.Error("merge stopped", "error", err, "removedRows", removed)
+		os.Exit(1)
+	}
+	// a running server keeps serving cached rows until they fall out of its cache
+	slog.Info("merge finished, restart a running server to drop its cached rows", "removedRows", removed)
+}
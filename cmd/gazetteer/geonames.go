@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+// column indexes of the GeoNames main export (cities500.txt, allCountries.txt, ...)
+const (
+	gnID           = 0
+	gnName         = 1
+	gnLat          = 4
+	gnLon          = 5
+	gnFeatureClass = 6
+	gnFeatureCode  = 7
+	gnCountry      = 8
+	gnAdmin1       = 10
+	gnAdmin2       = 11
+	gnPopulation   = 14
+	gnElevation    = 15
+	gnDem          = 16
+	gnTimezone     = 17
+	gnColumns      = 19
+)
+
+const geoNamesSource = "geonames"
+
+func newTsvScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return sc
+}
+
+// loadAdminCodes reads admin1CodesASCII.txt into "CC.code" -> name, or
+// admin2Codes.txt into "CC.admin1.code" -> name.
+func loadAdminCodes(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]string, 4096)
+	sc := newTsvScanner(f)
+	for sc.Scan() {
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) < 3 {
+			continue
+		}
+		names[cols[0]] = cols[2]
+	}
+	return names, sc.Err()
+}
+
+// populatedPlaceIDs lists the geoname ids of the populated places in a GeoNames
+// export, the rows readGeoNames keeps.
+func populatedPlaceIDs(path string) (map[int64]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := make(map[int64]struct{}, 1<<16)
+	sc := newTsvScanner(f)
+	for sc.Scan() {
+		cols := strings.SplitN(sc.Text(), "\t", gnFeatureClass+2)
+		if len(cols) <= gnFeatureClass || cols[gnFeatureClass] != "P" {
+			continue
+		}
+		if id, err := strconv.ParseInt(cols[gnID], 10, 64); err == nil {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, sc.Err()
+}
+
+// loadAlternateNames reads alternateNamesV2.txt keeping two letter language codes
+// of the ids in places only, which is the shape OpenWeather uses for local_names.
+// Preferred names win.
+func loadAlternateNames(path string, places map[int64]struct{}) (map[int64]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[int64]map[string]string, 1<<16)
+	sc := newTsvScanner(f)
+	for sc.Scan() {
+		// alternateNameId, geonameid, isolanguage, name, isPreferred, isShort, isColloquial, isHistoric, from, to
+		cols := strings.Split(sc.Text(), "\t")
+		if len(cols) < 8 || len(cols[2]) != 2 || cols[6] == "1" || cols[7] == "1" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(cols[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := places[id]; !ok {
+			continue
+		}
+
+		byLang := names[id]
+		if byLang == nil {
+			byLang = make(map[string]string, 4)
+			names[id] = byLang
+		}
+		if _, exists := byLang[cols[2]]; !exists || cols[4] == "1" {
+			byLang[cols[2]] = cols[3]
+		}
+	}
+	return names, sc.Err()
+}
+
+// featureClass sorts a populated place by its GeoNames feature code and population.
+func featureClass(code string, population int64) string {
+	switch {
+	case code == "PPLX":
+		return location.FeatureSuburb
+	case code == "PPLC" || code == "PPLA" || population >= 100_000:
+		return location.FeatureCity
+	case population >= 5_000 || strings.HasPrefix(code, "PPLA"):
+		return location.FeatureTown
+	default:
+		return location.FeatureVillage
+	}
+}
+
+// elevation prefers the surveyed value and falls back to the digital elevation model.
+func elevation(cols []string) *int32 {
+	for _, col := range [...]string{cols[gnElevation], cols[gnDem]} {
+		if v, err := strconv.ParseInt(col, 10, 32); err == nil && v != -9999 {
+			e := int32(v)
+			return &e
+		}
+	}
+	return nil
+}
+
+func readGeoNames(r io.Reader, admin1, admin2 map[string]string, alternates map[int64]map[string]string, emit func(database.GazetteerRow) error) error {
+	sc := newTsvScanner(r)
+	for sc.Scan() {
+		cols := strings.Split(sc.Text(), "\t")
+		// allCountries.txt also lists mountains, rivers, ... keep populated places only
+		if len(cols) < gnColumns || cols[gnFeatureClass] != "P" {
+			continue
+		}
+
+		lat, latErr := strconv.ParseFloat(cols[gnLat], 64)
+		lon, lonErr := strconv.ParseFloat(cols[gnLon], 64)
+		if latErr != nil || lonErr != nil {
+			continue
+		}
+		population, _ := strconv.ParseInt(cols[gnPopulation], 10, 64)
+
+		admin1Key := cols[gnCountry] + "." + cols[gnAdmin1]
+		row := database.GazetteerRow{
+			CityName:   cols[gnName],
+			State:      admin1[admin1Key],
+			Country:    cols[gnCountry],
+			Lat:        lat,
+			Lon:        lon,
+			Population: population,
+
+			County:       admin2[admin1Key+"."+cols[gnAdmin2]],
+			FeatureClass: featureClass(cols[gnFeatureCode], population),
+			Elevation:    elevation(cols),
+			Timezone:     cols[gnTimezone],
+			Source:       geoNamesSource,
+		}
+		if id, err := strconv.ParseInt(cols[gnID], 10, 64); err == nil {
+			row.LocalNames = alternates[id]
+		}
+
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+type countingReader struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+type importer struct {
+	db       *database.Database
+	source   string
+	skip     int64
+	seen     int64
+	batch    []database.GazetteerRow
+	size     int64
+	counter  *countingReader
+	started  time.Time
+	imported int64
+}
+
+func (imp *importer) emit(ctx context.Context, row database.GazetteerRow) error {
+	imp.seen++
+	if imp.seen <= imp.skip {
+		return nil
+	}
+
+	imp.batch = append(imp.batch, row)
+	if len(imp.batch) == cap(imp.batch) {
+		return imp.flush(ctx)
+	}
+	return nil
+}
+
+func (imp *importer) flush(ctx context.Context) error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+
+	if err := imp.db.ImportGazetteerBatch(ctx, imp.source, imp.batch, imp.seen); err != nil {
+		return fmt.Errorf("batch ending at row %d: %w", imp.seen, err)
+	}
+	imp.imported += int64(len(imp.batch))
+	imp.batch = imp.batch[:0]
+
+	elapsed := time.Since(imp.started)
+	slog.Info("progress",
+		"rows", imp.seen,
+		"imported", imp.imported,
+		"percent", fmt.Sprintf("%.1f", float64(imp.counter.read.Load())*100/float64(max(imp.size, 1))),
+		"rowsPerSec", int(float64(imp.imported)/max(elapsed.Seconds(), 0.001)),
+	)
+	return nil
+}
+
+func main() {
+	format := flag.String("format", "", "input format: geonames or openweather (guessed from the file extension when empty)")
+	admin1Path := flag.String("admin1", "", "GeoNames admin1CodesASCII.txt used to resolve state names")
+	admin2Path := flag.String("admin2", "", "GeoNames admin2Codes.txt used to resolve county names")
+	alternatesPath := flag.String("alternates", "", "GeoNames alternateNamesV2.txt merged into local_names")
+	batchSize := flag.Int("batch", 5000, "rows per COPY batch")
+	restart := flag.Bool("restart", false, "ignore the saved progress and import from the first row")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <cities500.txt|allCountries.txt|city.list.json>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	romanization, err := util.ParseRomanization(os.Getenv("ROMANIZE_CYRILLIC"), os.Getenv("ROMANIZE_GREEK"))
+	if err != nil {
+		slog.Error("invalid romanization", "error", err)
+		os.Exit(2)
+	}
+	util.SetRomanization(romanization)
+
+	path, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		slog.Error("invalid path", "error", err)
+		os.Exit(1)
+	}
+
+	if *format == "" {
+		*format = "geonames"
+		if strings.HasSuffix(path, ".json") {
+			*format = "openweather"
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	f, err := os.Open(path)
+	if err != nil {
+		slog.Error("could not open input", "error", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		slog.Error("could not stat input", "error", err)
+		os.Exit(1)
+	}
+
+	// a newer dump at the same path starts over instead of resuming at the old offset
+	source := fmt.Sprintf("%s@%d-%d", path, stat.Size(), stat.ModTime().Unix())
+
+	db := database.InitDB()
+	defer db.Pool.Close()
+
+	if *restart {
+		if err := db.ResetGazetteerProgress(ctx, source); err != nil {
+			slog.Error("could not reset progress", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	skip, err := db.GazetteerProgress(ctx, source)
+	if err != nil {
+		slog.Error("could not read progress", "error", err)
+		os.Exit(1)
+	}
+	if skip > 0 {
+		slog.Info("resuming import", "skipRows", skip)
+	}
+
+	imp := &importer{
+		db:      db,
+		source:  source,
+		skip:    skip,
+		batch:   make([]database.GazetteerRow, 0, max(*batchSize, 1)),
+		size:    stat.Size(),
+		counter: &countingReader{r: f},
+		started: time.Now(),
+	}
+	emit := func(row database.GazetteerRow) error {
+		return imp.emit(ctx, row)
+	}
+
+	switch *format {
+	case "geonames":
+		var admin1 map[string]string
+		if *admin1Path != "" {
+			admin1, err = loadAdminCodes(*admin1Path)
+			if err != nil {
+				slog.Error("could not load admin1 codes", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		var admin2 map[string]string
+		if *admin2Path != "" {
+			admin2, err = loadAdminCodes(*admin2Path)
+			if err != nil {
+				slog.Error("could not load admin2 codes", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		var alternates map[int64]map[string]string
+		if *alternatesPath != "" {
+			slog.Info("loading alternate names, this can take a while")
+			// the alternate names cover every feature, keep those of the places imported
+			var places map[int64]struct{}
+			places, err = populatedPlaceIDs(path)
+			if err == nil {
+				alternates, err = loadAlternateNames(*alternatesPath, places)
+			}
+			if err != nil {
+				slog.Error("could not load alternate names", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		err = readGeoNames(imp.counter, admin1, admin2, alternates, emit)
+	case "openweather":
+		err = readOpenWeatherCityList(imp.counter, emit)
+	default:
+		slog.Error("unknown format", "format", *format)
+		os.Exit(2)
+	}
+
+	if err == nil {
+		err = imp.flush(ctx)
+	}
+	if err != nil {
+		slog.Error("import stopped, rerun the same command to resume", "error", err, "committedRows", imp.seen-int64(len(imp.batch)))
+		os.Exit(1)
+	}
+
+	slog.Info("import finished", "rows", imp.seen, "imported", imp.imported, "took", time.Since(imp.started))
+}
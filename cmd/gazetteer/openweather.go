@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/7apri/SimpleGOWebserver/internal/api"
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+type owCity struct {
+	Name    string               `json:"name"`
+	State   string               `json:"state"`
+	Country string               `json:"country"`
+	Coord   location.Coordinates `json:"coord"`
+}
+
+// readOpenWeatherCityList streams city.list.json element by element, the file
+// is a single array far too large to decode at once.
+func readOpenWeatherCityList(r io.Reader, emit func(database.GazetteerRow) error) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+
+	for dec.More() {
+		var city owCity
+		if err := dec.Decode(&city); err != nil {
+			return err
+		}
+		if city.Name == "" || city.Country == "" {
+			continue
+		}
+
+		err := emit(database.GazetteerRow{
+			CityName: city.Name,
+			State:    city.State,
+			Country:  city.Country,
+			Lat:      city.Coord.Lat,
+			Lon:      city.Coord.Lon,
+			Source:   api.OpenWeatherSource,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,12 +7,14 @@ import (
 	"net/http"
 	"os"
 	"runtime/trace"
+	"strings"
 	"time"
 
 	"github.com/7apri/SimpleGOWebserver/internal/api"
 	"github.com/7apri/SimpleGOWebserver/internal/database"
 	"github.com/7apri/SimpleGOWebserver/internal/server"
 	"github.com/7apri/SimpleGOWebserver/internal/services"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
 )
 
 // //go:embed public/templates/* public/static/*
@@ -36,34 +38,81 @@ func main() {
 		os.Exit(1)
 	}
 
+	// names are stored romanized, keep this in step with the gazetteer import
+	romanization, err := util.ParseRomanization(os.Getenv("ROMANIZE_CYRILLIC"), os.Getenv("ROMANIZE_GREEK"))
+	if err != nil {
+		slog.Error("There was an error reading the romanization", "error", err)
+		os.Exit(1)
+	}
+	util.SetRomanization(romanization)
+
 	db := database.InitDB()
 	defer db.Pool.Close()
 
 	owClient := api.NewOwClient(weatherApiKey, (24*time.Hour)/1000)
 
-	ls, err := services.NewLocationService(db, 500, weatherApiKey, owClient, api.NewIpClient(time.Minute/40))
+	var ipClient api.IPGeolocator = services.NewIPBatcher(api.NewIpClient(time.Minute/40), 50*time.Millisecond, api.IpApiMaxBatch)
+	if mmdbPath := os.Getenv("IP_MMDB_PATH"); mmdbPath != "" {
+		mmdbClient, err := api.NewMMDBGeolocator(mmdbPath, time.Minute)
+		if err != nil {
+			slog.Error("There was an error loading the ip database", "error", err)
+			os.Exit(1)
+		}
+		ipClient = &api.FallbackGeolocator{Primary: mmdbClient, Fallback: ipClient}
+	}
+
+	ls, err := services.NewLocationService(db, 500, weatherApiKey, owClient, ipClient,
+		util.GetEnvFloat("LOCATION_MATCH_RADIUS_KM", 5), util.GetEnvDuration("IP_CACHE_TTL", 7*24*time.Hour),
+		util.GetEnvInt("GEOCODE_CELL_PRECISION", 6))
 	if err != nil {
 		slog.Error("There was an error creating the location service", "error", err)
 		os.Exit(1)
 	}
-	ws, err := services.NewWeatherService(db, 500)
+	ws, err := services.NewWeatherService(db, 500, owClient,
+		util.GetEnvInt("WEATHER_CELL_PRECISION", 5), util.GetEnvDuration("WEATHER_CACHE_TTL", 10*time.Minute))
 	if err != nil {
 		slog.Error("There was an error creating the weather service", "error", err)
 		os.Exit(1)
 	}
 
+	clientIP, err := server.NewClientIPResolver(strings.Split(os.Getenv("TRUSTED_PROXIES"), ","))
+	if err != nil {
+		slog.Error("There was an error parsing TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
+
 	srv := &server.Server{
 		LocationService: ls,
 		WeatherService:  ws,
 		Database:        db,
+		ClientIP:        clientIP,
+		AdminToken:      os.Getenv("ADMIN_TOKEN"),
 	}
 
 	http.HandleFunc("/", srv.HandleRoot)
 
-	http.HandleFunc("/api/health", srv.HandleHealth)
-
-	http.HandleFunc("/api/weather", srv.HandleWeather)
-	http.HandleFunc("/api/location", srv.HandleLocation)
+	http.HandleFunc("GET /api/health", srv.HandleHealth)
+
+	http.HandleFunc("GET /api/weather", srv.HandleWeather)
+	http.HandleFunc("GET /api/location", srv.HandleLocation)
+	http.HandleFunc("GET /api/location/{id}", srv.HandleLocationByID)
+	http.HandleFunc("POST /api/location/batch", srv.HandleLocationBatch)
+	http.HandleFunc("GET /api/location/within", srv.HandleLocationWithin)
+	http.HandleFunc("GET /api/location/bbox", srv.HandleLocationBox)
+	http.HandleFunc("GET /api/location/suggest", srv.HandleLocationSuggest)
+	http.HandleFunc("GET /api/geo/distance", srv.HandleDistance)
+	http.HandleFunc("PUT /api/me/default-location", srv.HandleSetDefaultLocation)
+	http.HandleFunc("DELETE /api/me/default-location", srv.HandleClearDefaultLocation)
+	http.HandleFunc("GET /api/countries", srv.HandleCountries)
+	http.HandleFunc("GET /api/countries/{cc}/subdivisions", srv.HandleCountrySubdivisions)
+	http.HandleFunc("GET /api/countries/{cc}/cities", srv.HandleCountryCities)
+
+	http.HandleFunc("POST /api/admin/locations", srv.RequireAdmin(srv.HandleAdminCreateLocation))
+	http.HandleFunc("PATCH /api/admin/locations/{id}", srv.RequireAdmin(srv.HandleAdminUpdateLocation))
+	http.HandleFunc("DELETE /api/admin/locations/{id}", srv.RequireAdmin(srv.HandleAdminDeleteLocation))
+	http.HandleFunc("POST /api/admin/locations/{id}/merge", srv.RequireAdmin(srv.HandleAdminMergeLocations))
+	http.HandleFunc("GET /api/admin/locations/duplicates", srv.RequireAdmin(srv.HandleAdminDuplicates))
+	http.HandleFunc("POST /api/admin/locations/duplicates/merge", srv.RequireAdmin(srv.HandleAdminMergeDuplicates))
 
 	http.HandleFunc("/api/login", srv.HandleLogin)
 	http.HandleFunc("/api/register", srv.HandleRegister)
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,15 +14,28 @@ import (
 	"golang.org/x/time/rate"
 )
 
+const (
+	IpApiSource       = "ip-api"
+	OpenWeatherSource = "openweather"
+)
+
+// ip-api allows up to 100 addresses per batch call and 15 batch calls a minute.
+const (
+	IpApiMaxBatch   = 100
+	ipApiBatchLimit = time.Minute / 15
+)
+
 type IpApiClient struct {
-	HTTP    *http.Client
-	limiter *rate.Limiter
+	HTTP         *http.Client
+	limiter      *rate.Limiter
+	batchLimiter *rate.Limiter
 }
 
 func NewIpClient(limit time.Duration) *IpApiClient {
 	return &IpApiClient{
-		HTTP:    &http.Client{Timeout: 10 * time.Second},
-		limiter: rate.NewLimiter(rate.Every(limit), 1),
+		HTTP:         &http.Client{Timeout: 10 * time.Second},
+		limiter:      rate.NewLimiter(rate.Every(limit), 1),
+		batchLimiter: rate.NewLimiter(rate.Every(ipApiBatchLimit), 1),
 	}
 }
 
@@ -48,10 +62,70 @@ func (c *IpApiClient) IpToCoordinates(ctx context.Context, ip string) (*lc.IpGeo
 	if result.Status == "fail" {
 		return nil, fmt.Errorf("ip geo failed for: %s", ip)
 	}
+	result.Source = IpApiSource
 
 	return &result, nil
 }
 
+// IpsToCoordinates resolves up to IpApiMaxBatch addresses in one call. The result
+// is aligned with ips, failed lookups are left nil.
+func (c *IpApiClient) IpsToCoordinates(ctx context.Context, ips []string) ([]*lc.IpGeoResult, error) {
+	if len(ips) > IpApiMaxBatch {
+		return nil, fmt.Errorf("ip batch of %d exceeds the limit of %d", len(ips), IpApiMaxBatch)
+	}
+	if err := c.batchLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	slog.Debug("ip-api batch request", "size", len(ips))
+
+	body, err := json.Marshal(ips)
+	if err != nil {
+		return nil, err
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://ip-api.com/batch?lang=en", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		slog.Warn("ip-api batch request failed", "size", len(ips), "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("ip-api batch rejected", "size", len(ips), "status", resp.StatusCode)
+		return nil, fmt.Errorf("ip batch failed with status %d", resp.StatusCode)
+	}
+
+	var decoded []lc.IpGeoResult
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		slog.Warn("ip-api batch answer unreadable", "size", len(ips), "status", resp.StatusCode, "error", err)
+		return nil, err
+	}
+	if len(decoded) != len(ips) {
+		err := fmt.Errorf("ip batch returned %d results for %d addresses", len(decoded), len(ips))
+		slog.Warn("ip-api batch answer incomplete", "size", len(ips), "results", len(decoded))
+		return nil, err
+	}
+
+	results := make([]*lc.IpGeoResult, len(ips))
+	for i := range decoded {
+		if decoded[i].Status == "success" {
+			decoded[i].Source = IpApiSource
+			results[i] = &decoded[i]
+		}
+	}
+
+	return results, nil
+}
+
+// Geocoder turns addresses and postal codes into places and coordinates back into addresses.
+type Geocoder interface {
+	Geolocate(ctx context.Context, adress *lc.LocationReadableAddress) ([]lc.GeoResult, error)
+	ReverseGeolocate(ctx context.Context, coords *lc.Coordinates) ([]lc.GeoResult, error)
+	GeolocateZip(ctx context.Context, zip, country string) (*lc.GeoResult, error)
+}
+
 func (c *OpenWeatherClient) ReverseGeolocate(ctx context.Context, coords *lc.Coordinates) ([]lc.GeoResult, error) {
 	if err := c.limiter.Wait(ctx); err != nil {
 		return nil, err
@@ -75,6 +149,9 @@ func (c *OpenWeatherClient) ReverseGeolocate(ctx context.Context, coords *lc.Coo
 	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
 		return nil, err
 	}
+	for i := range results {
+		results[i].Source = OpenWeatherSource
+	}
 
 	return results, nil
 }
@@ -101,8 +178,50 @@ func (c *OpenWeatherClient) Geolocate(ctx context.Context, adress *lc.LocationRe
 	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
 		return nil, err
 	}
+	for i := range results {
+		results[i].Source = OpenWeatherSource
+	}
 
 	return results, nil
 }
 
+// GeolocateZip looks up a postal code, OpenWeather answers with a single place.
+func (c *OpenWeatherClient) GeolocateZip(ctx context.Context, zip, country string) (*lc.GeoResult, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	slog.Debug("OpenWeather zip request", "zip", zip, "country", country)
+
+	u := fmt.Sprintf("http://api.openweathermap.org/geo/1.0/zip?zip=%s&appid=%s",
+		url.QueryEscape(zip+","+country),
+		c.apiKey,
+	)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		slog.Warn("OpenWeather zip request failed", "zip", zip, "country", country, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// 404 is an unknown code, anything else is the provider failing
+		if resp.StatusCode != http.StatusNotFound {
+			slog.Warn("OpenWeather zip request rejected", "zip", zip, "country", country, "status", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("postal code %s in %s not found (status %d)", zip, country, resp.StatusCode)
+	}
+
+	var result lc.GeoResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		slog.Warn("OpenWeather zip answer unreadable", "zip", zip, "country", country, "error", err)
+		return nil, err
+	}
+	result.PostalCode = zip
+	result.Source = OpenWeatherSource
+
+	return &result, nil
+}
+
 //http://localhost/api/location?city=Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague,Prague&country=CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ,CZ&state=-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-,-
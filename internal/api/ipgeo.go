@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	lc "github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/7apri/SimpleGOWebserver/internal/mmdb"
+)
+
+const MMDBSource = "mmdb"
+
+type IPGeolocator interface {
+	IpToCoordinates(ctx context.Context, ip string) (*lc.IpGeoResult, error)
+}
+
+// MMDBGeolocator answers from a local GeoLite2-City or DB-IP Lite City file and
+// swaps in a new reader whenever the file on disk changes.
+type MMDBGeolocator struct {
+	path    string
+	reader  atomic.Pointer[mmdb.Reader]
+	modTime time.Time
+	size    int64
+}
+
+func NewMMDBGeolocator(path string, reloadEvery time.Duration) (*MMDBGeolocator, error) {
+	g := &MMDBGeolocator{path: path}
+	if err := g.reload(); err != nil {
+		return nil, err
+	}
+
+	go g.watch(reloadEvery)
+	return g, nil
+}
+
+func (g *MMDBGeolocator) reload() error {
+	stat, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+	if stat.ModTime().Equal(g.modTime) && stat.Size() == g.size {
+		return nil
+	}
+
+	r, err := mmdb.Open(g.path)
+	if err != nil {
+		return err
+	}
+
+	g.reader.Store(r)
+	g.modTime, g.size = stat.ModTime(), stat.Size()
+	slog.Info("loaded ip database", "path", g.path, "type", r.DatabaseType, "build", time.Unix(int64(r.BuildEpoch), 0))
+	return nil
+}
+
+func (g *MMDBGeolocator) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// a half written file fails to parse, the old reader stays until the next tick
+		if err := g.reload(); err != nil {
+			slog.Warn("ip database reload failed", "path", g.path, "error", err)
+		}
+	}
+}
+
+func (g *MMDBGeolocator) IpToCoordinates(ctx context.Context, ip string) (*lc.IpGeoResult, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip: %s", ip)
+	}
+
+	record, err := g.reader.Load().Lookup(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lat, latOk := lookupPath(record, "location", "latitude").(float64)
+	lon, lonOk := lookupPath(record, "location", "longitude").(float64)
+	if !latOk || !lonOk {
+		return nil, mmdb.ErrNotFound
+	}
+
+	city, _ := lookupPath(record, "city", "names", "en").(string)
+	state, _ := lookupPath(record, "subdivisions", 0, "names", "en").(string)
+	country, _ := lookupPath(record, "country", "iso_code").(string)
+	timezone, _ := lookupPath(record, "location", "time_zone").(string)
+
+	return &lc.IpGeoResult{
+		Status:      "success",
+		Source:      MMDBSource,
+		Country:     country,
+		State:       state,
+		CityName:    city,
+		Timezone:    timezone,
+		Coordinates: lc.Coordinates{Lat: lat, Lon: lon},
+	}, nil
+}
+
+// lookupPath walks a decoded record by map keys (string) and array indexes (int).
+func lookupPath(v any, path ...any) any {
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = m[key]
+		case int:
+			a, ok := v.([]any)
+			if !ok || key >= len(a) {
+				return nil
+			}
+			v = a[key]
+		}
+	}
+	return v
+}
+
+// FallbackGeolocator only asks Fallback for addresses Primary cannot resolve.
+type FallbackGeolocator struct {
+	Primary  IPGeolocator
+	Fallback IPGeolocator
+}
+
+func (f *FallbackGeolocator) IpToCoordinates(ctx context.Context, ip string) (*lc.IpGeoResult, error) {
+	res, err := f.Primary.IpToCoordinates(ctx, ip)
+	if err == nil {
+		return res, nil
+	}
+	return f.Fallback.IpToCoordinates(ctx, ip)
+}
@@ -9,17 +9,19 @@ import (
 )
 
 type TieredCache[V any, K comparable] struct {
-	hot         atomic.Value // just a *sync.Map (for poiter swap)
-	counters    atomic.Value // just a *sync.Map
-	cold        *ShardedCache[V, K]
-	threshold   int64
-	promoChan   chan promoTask[V, K]
-	marshalFunc func(V) ([]byte, error)
+	hot       atomic.Value // just a *sync.Map (for poiter swap)
+	counters  atomic.Value // just a *sync.Map
+	cold      *ShardedCache[V, K]
+	threshold int64
+	promoChan chan promoTask[V, K]
+	encoders  []func(V) ([]byte, error)
 }
 
+// HotEntry keeps the value together with its pre-encoded forms, one per
+// encoder in the order they were passed to NewTieredCache.
 type HotEntry[V any] struct {
-	Data      V
-	JSONBytes []byte
+	Data    V
+	Encoded [][]byte
 }
 
 type promoTask[V any, K comparable] struct {
@@ -27,12 +29,12 @@ type promoTask[V any, K comparable] struct {
 	val V
 }
 
-func NewTieredCache[V any, K comparable](lruSize int, lruShardCount int, promoteThreshold int64, promoChanBuffer int, marshal func(V) ([]byte, error), hashFunc func(K) uint32) *TieredCache[V, K] {
+func NewTieredCache[V any, K comparable](lruSize int, lruShardCount int, promoteThreshold int64, promoChanBuffer int, encoders []func(V) ([]byte, error), hashFunc func(K) uint32) *TieredCache[V, K] {
 	tc := &TieredCache[V, K]{
-		cold:        NewShardedCache[V](lruSize, lruShardCount, hashFunc),
-		threshold:   promoteThreshold,
-		promoChan:   make(chan promoTask[V, K], promoChanBuffer),
-		marshalFunc: marshal,
+		cold:      NewShardedCache[V](lruSize, lruShardCount, hashFunc),
+		threshold: promoteThreshold,
+		promoChan: make(chan promoTask[V, K], promoChanBuffer),
+		encoders:  encoders,
 	}
 	tc.hot.Store(&sync.Map{})
 	tc.counters.Store(&sync.Map{})
@@ -65,24 +67,37 @@ func (tc *TieredCache[V, K]) promote(key K, val V) {
 
 	hits := counter.Add(1)
 	if hits == tc.threshold {
-		b, err := tc.marshalFunc(val)
-		if err != nil {
-			return
+		encoded := make([][]byte, len(tc.encoders))
+		for i, encode := range tc.encoders {
+			b, err := encode(val)
+			if err != nil {
+				return
+			}
+			encoded[i] = b
 		}
 		hot.Store(key, &HotEntry[V]{
-			Data:      val,
-			JSONBytes: b,
+			Data:    val,
+			Encoded: encoded,
 		})
 		counters.Delete(key)
 	}
 }
 
+// Get returns the value and, for hot entries, its form produced by the first encoder.
 func (tc *TieredCache[V, K]) Get(key K) (V, []byte, bool) {
+	return tc.GetEncoded(key, 0)
+}
+
+// GetEncoded is Get with the pre-encoded bytes of the encoder at index enc.
+func (tc *TieredCache[V, K]) GetEncoded(key K, enc int) (V, []byte, bool) {
 	hot := tc.hot.Load().(*sync.Map)
 
 	if val, ok := hot.Load(key); ok {
 		entry := val.(*HotEntry[V])
-		return entry.Data, entry.JSONBytes, true
+		if enc < len(entry.Encoded) {
+			return entry.Data, entry.Encoded[enc], true
+		}
+		return entry.Data, nil, true
 	}
 	val, ok := tc.cold.Get(key)
 	if ok {
@@ -98,6 +113,22 @@ func (tc *TieredCache[V, K]) Add(key K, val V) {
 	tc.cold.Add(key, val)
 }
 
+// RemoveIf drops every entry, hot or cold, the predicate matches. It walks the
+// whole cache so it is meant for rare invalidations, not the request path.
+func (tc *TieredCache[V, K]) RemoveIf(match func(key K, val V) bool) {
+	tc.cold.RemoveIf(match)
+
+	hot := tc.hot.Load().(*sync.Map)
+	counters := tc.counters.Load().(*sync.Map)
+	hot.Range(func(k, v any) bool {
+		if match(k.(K), v.(*HotEntry[V]).Data) {
+			hot.Delete(k)
+			counters.Delete(k)
+		}
+		return true
+	})
+}
+
 type ShardedCache[V any, K comparable] struct {
 	shards   []*lru.Cache[K, V]
 	mask     uint32
@@ -127,3 +158,13 @@ func (sc *ShardedCache[V, K]) Get(key K) (V, bool) {
 func (sc *ShardedCache[V, K]) Add(key K, val V) {
 	sc.getShard(key).Add(key, val)
 }
+
+func (sc *ShardedCache[V, K]) RemoveIf(match func(key K, val V) bool) {
+	for _, shard := range sc.shards {
+		for _, key := range shard.Keys() {
+			if val, ok := shard.Peek(key); ok && match(key, val) {
+				shard.Remove(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+	"github.com/bytedance/sonic"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	ErrLocationNotFound = errors.New("location not found")
+	ErrLocationExists   = errors.New("a location with this name, state and country already exists")
+)
+
+func translateLocationErr(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrLocationNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrLocationExists
+	}
+	return err
+}
+
+func marshalNames(names map[string]string) []byte {
+	if len(names) == 0 {
+		return nil
+	}
+	b, _ := sonic.Marshal(names)
+	return b
+}
+
+func (db *Database) CreateLocation(ctx context.Context, loc *location.GeoResult) (*location.GeoResult, error) {
+	query := `
+        INSERT INTO locations (city_name, state, country, lat, lon, local_names,
+            county, postal_code, population, feature_class, elevation, timezone, source)
+        VALUES ($1, $2, $3, $4, $5, $6,
+            NULLIF($7, ''), NULLIF($8, ''), NULLIF($9::bigint, 0), NULLIF($10, ''), $11, NULLIF($12, ''), 'admin')
+        RETURNING ` + locationColumns
+
+	created, err := scanLocation(db.Pool.QueryRow(ctx, query,
+		util.CleanQuery(loc.CityName), location.CanonicalState(loc.Country, loc.State), loc.Country, loc.Lat, loc.Lon, marshalNames(loc.LocalNames),
+		loc.County, loc.PostalCode, loc.Population, loc.FeatureClass, loc.Elevation, loc.Timezone))
+	return created, translateLocationErr(err)
+}
+
+// UpdateLocation overwrites the row and returns it as it was before and after.
+func (db *Database) UpdateLocation(ctx context.Context, id int64, loc *location.GeoResult) (before, after *location.GeoResult, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	before, err = scanLocation(tx.QueryRow(ctx, `
+        SELECT `+locationColumns+`
+        FROM locations WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, nil, translateLocationErr(err)
+	}
+
+	after, err = scanLocation(tx.QueryRow(ctx, `
+        UPDATE locations
+        SET city_name = $2, state = $3, country = $4, lat = $5, lon = $6, local_names = $7,
+            county = NULLIF($8, ''), postal_code = NULLIF($9, ''), population = NULLIF($10::bigint, 0),
+            feature_class = NULLIF($11, ''), elevation = $12, timezone = NULLIF($13, '')
+        WHERE id = $1
+        RETURNING `+locationColumns,
+		id, util.CleanQuery(loc.CityName), location.CanonicalState(loc.Country, loc.State), loc.Country, loc.Lat, loc.Lon, marshalNames(loc.LocalNames),
+		loc.County, loc.PostalCode, loc.Population, loc.FeatureClass, loc.Elevation, loc.Timezone))
+	if err != nil {
+		return nil, nil, translateLocationErr(err)
+	}
+
+	return before, after, tx.Commit(ctx)
+}
+
+// DeleteLocation removes the row, its weather and ip rows go with it through ON DELETE CASCADE.
+func (db *Database) DeleteLocation(ctx context.Context, id int64) (*location.GeoResult, error) {
+	deleted, err := scanLocation(db.Pool.QueryRow(ctx, `
+        DELETE FROM locations WHERE id = $1
+        RETURNING `+locationColumns, id))
+	return deleted, translateLocationErr(err)
+}
+
+// MergeLocations folds the source rows into target: weather, history, ip and
+// postal code rows are repointed, local_names are merged with the target's own names winning, and
+// the sources are deleted. It returns every row as it was before the merge.
+func (db *Database) MergeLocations(ctx context.Context, targetID int64, sourceIDs []int64) ([]*location.GeoResult, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+        SELECT `+locationColumns+`
+        FROM locations WHERE id = $1 OR id = ANY($2)
+        ORDER BY id FOR UPDATE`, targetID, sourceIDs)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*location.GeoResult, error) {
+		return scanLocation(row)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(affected) != len(sourceIDs)+1 {
+		return nil, ErrLocationNotFound
+	}
+
+	steps := []string{
+		// the newest current weather of the group wins
+		`INSERT INTO weather_current_cache (location_id, full_data, updated_at)
+         SELECT $1, full_data, updated_at FROM weather_current_cache
+         WHERE location_id = ANY($2)
+         ORDER BY updated_at DESC LIMIT 1
+         ON CONFLICT (location_id) DO UPDATE SET full_data = EXCLUDED.full_data, updated_at = EXCLUDED.updated_at
+         WHERE weather_current_cache.updated_at < EXCLUDED.updated_at`,
+		// history days the target already has are kept as they are
+		`INSERT INTO weather_history (location_id, recorded_date, temp_day, weather_description, raw_data)
+         SELECT DISTINCT ON (recorded_date) $1, recorded_date, temp_day, weather_description, raw_data
+         FROM weather_history WHERE location_id = ANY($2)
+         ORDER BY recorded_date, id DESC
+         ON CONFLICT (location_id, recorded_date) DO NOTHING`,
+		`UPDATE ip_geolocation SET location_id = $1 WHERE location_id = ANY($2)`,
+		`UPDATE postal_codes SET location_id = $1 WHERE location_id = ANY($2)`,
+		// components the target lacks come from the most populated source
+		`UPDATE locations t SET
+             county = COALESCE(t.county, s.county),
+             postal_code = COALESCE(t.postal_code, s.postal_code),
+             population = COALESCE(t.population, s.population),
+             feature_class = COALESCE(t.feature_class, s.feature_class),
+             elevation = COALESCE(t.elevation, s.elevation),
+             timezone = COALESCE(t.timezone, s.timezone),
+             source = COALESCE(t.source, s.source)
+         FROM (SELECT * FROM locations WHERE id = ANY($2) ORDER BY population DESC NULLS LAST, id LIMIT 1) s
+         WHERE t.id = $1`,
+		`UPDATE locations SET local_names = NULLIF(
+             COALESCE((SELECT jsonb_object_agg(key, value) FROM (
+                 SELECT DISTINCT ON (n.key) n.key, n.value
+                 FROM locations s, jsonb_each(s.local_names) n
+                 WHERE s.id = ANY($2)
+                 ORDER BY n.key, s.id
+             ) merged), '{}'::jsonb) || COALESCE(local_names, '{}'::jsonb), '{}'::jsonb)
+         WHERE id = $1`,
+		`DELETE FROM locations WHERE id = ANY($2)`,
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(ctx, step, targetID, sourceIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return affected, tx.Commit(ctx)
+}
+
+// DuplicateCandidates lists the rows sharing their normalized name and country
+// with at least one other row. The normalized name ignores case, spaces, dashes
+// and punctuation, so "frydek-mistek" and "frydek mistek" fall together.
+func (db *Database) DuplicateCandidates(ctx context.Context, country string) ([]DuplicateCandidate, error) {
+	rows, err := db.Pool.Query(ctx, `
+        SELECT `+locationColumns+`, norm
+        FROM (
+            SELECT *,
+                   regexp_replace(lower(city_name), '[^[:alnum:]]+', '', 'g') AS norm,
+                   count(*) OVER (PARTITION BY regexp_replace(lower(city_name), '[^[:alnum:]]+', '', 'g'), country) AS group_size
+            FROM locations
+            WHERE $1::text = '' OR country = $1::text
+        ) grouped
+        WHERE group_size > 1
+        ORDER BY country, norm, id`, country)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (DuplicateCandidate, error) {
+		var c DuplicateCandidate
+		loc, err := scanLocation(row, &c.NormalizedName)
+		if err == nil {
+			c.GeoResult = *loc
+		}
+		return c, err
+	})
+}
+
+type DuplicateCandidate struct {
+	location.GeoResult
+	NormalizedName string
+}
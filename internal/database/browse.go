@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/jackc/pgx/v5"
+)
+
+// CountLocationsByCountry returns the number of rows per country code.
+func (db *Database) CountLocationsByCountry(ctx context.Context) (map[string]int64, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT country, count(*) FROM locations GROUP BY country`)
+	if err != nil {
+		return nil, err
+	}
+	return collectCounts(rows)
+}
+
+// CountLocationsByState returns the number of rows per stored state of one
+// country, rows without a state are counted under "".
+func (db *Database) CountLocationsByState(ctx context.Context, country string) (map[string]int64, error) {
+	rows, err := db.Pool.Query(ctx, `
+        SELECT COALESCE(state, ''), count(*)
+        FROM locations
+        WHERE country = $1
+        GROUP BY 1`, country)
+	if err != nil {
+		return nil, err
+	}
+	return collectCounts(rows)
+}
+
+func collectCounts(rows pgx.Rows) (map[string]int64, error) {
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var key string
+		var n int64
+		if err := rows.Scan(&key, &n); err != nil {
+			return nil, err
+		}
+		counts[key] = n
+	}
+	return counts, rows.Err()
+}
+
+// FindLocationsInRegion lists the rows of a country, or of one of its states
+// when state is set, the most populated first.
+func (db *Database) FindLocationsInRegion(ctx context.Context, country, state string, limit, offset int) ([]location.GeoResult, error) {
+	var b strings.Builder
+	args := []any{country, limit, offset}
+
+	b.WriteString(`
+        SELECT ` + locationColumns + `
+        FROM locations
+        WHERE country = $1 `)
+	if state != "" {
+		b.WriteString("AND state = $4 ")
+		args = append(args, state)
+	}
+	b.WriteString("ORDER BY population DESC NULLS LAST, city_name, id LIMIT $2 OFFSET $3")
+
+	rows, err := db.Pool.Query(ctx, b.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectLocations(rows)
+}
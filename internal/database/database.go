@@ -9,9 +9,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
 	"github.com/7apri/SimpleGOWebserver/internal/location"
 	util "github.com/7apri/SimpleGOWebserver/pkg"
 	"github.com/bytedance/sonic"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -49,8 +51,13 @@ func InitDB() *Database {
 		panic(fmt.Sprintf("Failed to run schema migration: %v", err))
 	}
 
+	db := &Database{pool}
+	if err := db.runDataMigrations(context.TODO()); err != nil {
+		panic(fmt.Sprintf("Failed to run data migration: %v", err))
+	}
+
 	fmt.Println("Successfully connected to Postgres!")
-	return &Database{pool}
+	return db
 }
 
 func (db *Database) GetLatency() (string, error) {
@@ -63,93 +70,329 @@ func (db *Database) GetLatency() (string, error) {
 	return time.Since(start).String(), nil
 }
 
-func (db *Database) SaveLocation(loc *location.GeoResult) error {
-	query := `
-        INSERT INTO locations (city_name, state, country, lat, lon, local_names)
-        VALUES ($1, $2, $3, $4, $5, $6)
-        ON CONFLICT (city_name, state, country) DO NOTHING`
+// locationColumnsFmt is the select list scanLocation expects, %[1]s is an
+// optional table alias with its dot.
+const locationColumnsFmt = `%[1]sid, %[1]scity_name, COALESCE(%[1]sstate, ''), %[1]scountry, %[1]slat, %[1]slon, %[1]slocal_names,
+            COALESCE(%[1]scounty, ''), COALESCE(%[1]spostal_code, ''), COALESCE(%[1]spopulation, 0),
+            COALESCE(%[1]sfeature_class, ''), %[1]selevation, COALESCE(%[1]stimezone, ''), COALESCE(%[1]ssource, '')`
+
+var (
+	locationColumns       = fmt.Sprintf(locationColumnsFmt, "")
+	locationColumnsJoined = fmt.Sprintf(locationColumnsFmt, "l.")
+)
+
+// scanLocation reads a row selected with locationColumns, extra receives the
+// columns listed after them.
+func scanLocation(row pgx.Row, extra ...any) (*location.GeoResult, error) {
+	var loc location.GeoResult
+	var namesRaw []byte
+
+	dest := append([]any{
+		&loc.ID, &loc.CityName, &loc.State, &loc.Country, &loc.Lat, &loc.Lon, &namesRaw,
+		&loc.County, &loc.PostalCode, &loc.Population, &loc.FeatureClass, &loc.Elevation, &loc.Timezone, &loc.Source,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	if len(namesRaw) > 0 {
+		sonic.Unmarshal(namesRaw, &loc.LocalNames)
+	}
+	return &loc, nil
+}
+
+// saveLocationSQL inserts a location unless it is already known, in which case
+// only the components the row is missing are filled in. A known row with nothing
+// to fill in is not updated, that would write a dead tuple on every save, so its
+// id comes from a plain SELECT. The statement continues with a query of loc.
+const saveLocationSQL = `
+        WITH saved AS (
+            INSERT INTO locations (city_name, state, country, lat, lon, local_names,
+                county, postal_code, population, feature_class, elevation, timezone, source)
+            VALUES ($1, $2, $3, $4, $5, $6,
+                NULLIF($7, ''), NULLIF($8, ''), NULLIF($9::bigint, 0), NULLIF($10, ''), $11, NULLIF($12, ''), NULLIF($13, ''))
+            ON CONFLICT (city_name, country, state) DO UPDATE SET
+                county = COALESCE(locations.county, EXCLUDED.county),
+                postal_code = COALESCE(locations.postal_code, EXCLUDED.postal_code),
+                population = COALESCE(locations.population, EXCLUDED.population),
+                feature_class = COALESCE(locations.feature_class, EXCLUDED.feature_class),
+                elevation = COALESCE(locations.elevation, EXCLUDED.elevation),
+                timezone = COALESCE(locations.timezone, EXCLUDED.timezone),
+                source = COALESCE(locations.source, EXCLUDED.source)
+            WHERE (locations.county IS NULL AND EXCLUDED.county IS NOT NULL)
+                OR (locations.postal_code IS NULL AND EXCLUDED.postal_code IS NOT NULL)
+                OR (locations.population IS NULL AND EXCLUDED.population IS NOT NULL)
+                OR (locations.feature_class IS NULL AND EXCLUDED.feature_class IS NOT NULL)
+                OR (locations.elevation IS NULL AND EXCLUDED.elevation IS NOT NULL)
+                OR (locations.timezone IS NULL AND EXCLUDED.timezone IS NOT NULL)
+                OR (locations.source IS NULL AND EXCLUDED.source IS NOT NULL)
+            RETURNING id
+        ), loc AS (
+            SELECT id FROM saved
+            UNION ALL
+            SELECT id FROM locations WHERE city_name = $1 AND country = $3 AND state = $2
+            LIMIT 1
+        )`
 
+func saveLocationArgs(loc *location.GeoResult) []any {
 	var namesJson []byte
 	if len(loc.LocalNames) > 0 {
 		namesJson, _ = sonic.Marshal(loc.LocalNames)
 	}
 
-	_, err := db.Pool.Exec(context.TODO(), query, util.CleanQuery(loc.CityName), util.CleanQuery(loc.State), loc.Country, loc.Lat, loc.Lon, namesJson)
-	return err
+	return []any{
+		util.CleanQuery(loc.CityName), location.CanonicalState(loc.Country, loc.State), loc.Country, loc.Lat, loc.Lon, namesJson,
+		loc.County, loc.PostalCode, loc.Population, loc.FeatureClass, loc.Elevation, loc.Timezone, loc.Source,
+	}
+}
+
+// SaveLocation inserts the location unless it is already known and returns its id either way.
+func (db *Database) SaveLocation(ctx context.Context, loc *location.GeoResult) (int64, error) {
+	var id int64
+	err := db.Pool.QueryRow(ctx, saveLocationSQL+` SELECT id FROM loc`, saveLocationArgs(loc)...).Scan(&id)
+	return id, err
+}
+
+func (db *Database) FindLocationByID(ctx context.Context, id int64) (*location.GeoResult, error) {
+	query := `
+        SELECT ` + locationColumns + `
+        FROM locations
+        WHERE id = $1`
+
+	return scanLocation(db.Pool.QueryRow(ctx, query, id))
+}
+
+// haversineSQL is the great-circle distance in km between the row and ($1, $2).
+const haversineSQL = `2 * 6371.0088 * asin(sqrt(least(1,
+            power(sin(radians(lat - $1::float) / 2), 2) +
+            cos(radians($1::float)) * cos(radians(lat)) * power(sin(radians(lon - $2::float) / 2), 2))))`
+
+// haversineAt is haversineSQL measured from the parameters $lat and $lon.
+func haversineAt(lat, lon int) string {
+	return strings.NewReplacer(
+		"$1::", fmt.Sprintf("$%d::", lat),
+		"$2::", fmt.Sprintf("$%d::", lon),
+	).Replace(haversineSQL)
 }
 
-func (db *Database) FindLocationByCoords(ctx context.Context, coords *location.Coordinates) (*location.GeoResult, error) {
+func (db *Database) FindLocationByCoords(ctx context.Context, coords *location.Coordinates, maxRadiusKm float64) (*location.GeoResult, error) {
 	if coords == nil {
 		return nil, errors.New("coordinates cannot be nil")
 	}
 
-	const threshold float64 = 0.005
+	latDelta, lonDelta := geo.BoundingBox(*coords, maxRadiusKm)
 	query := `
-        SELECT city_name, state, country, lat, lon, local_names
-        FROM locations
-        WHERE lat BETWEEN ($1::float - $3::float) AND ($1::float + $3::float)
-          AND lon BETWEEN ($2::float - $3::float) AND ($2::float + $3::float)
+        SELECT ` + locationColumns + `, dist
+        FROM (
+            SELECT *, ` + haversineSQL + ` AS dist
+            FROM locations
+            WHERE lat BETWEEN ($1::float - $4::float) AND ($1::float + $4::float)
+              AND (abs(lon - $2::float) <= $5::float OR abs(lon - $2::float) >= 360 - $5::float)
+        ) nearby
+        WHERE dist <= $3::float
+        ORDER BY dist
         LIMIT 1`
 
-	var loc location.GeoResult
-	var namesRaw []byte
-
-	err := db.Pool.QueryRow(ctx, query, coords.Lat, coords.Lon, threshold).Scan(
-		&loc.CityName,
-		&loc.State,
-		&loc.Country,
-		&loc.Lat,
-		&loc.Lon,
-		&namesRaw,
-	)
+	var dist float64
+	loc, err := scanLocation(db.Pool.QueryRow(ctx, query, coords.Lat, coords.Lon, maxRadiusKm, latDelta, lonDelta), &dist)
 	if err != nil {
 		return nil, err
 	}
+	loc.DistanceKm = &dist
 
-	if len(namesRaw) > 0 {
-		sonic.Unmarshal(namesRaw, &loc.LocalNames)
-	}
-
-	return &loc, nil
+	return loc, nil
 }
 
-func (db *Database) FindLocationByAddress(ctx context.Context, locIN *location.LocationReadableAddress) (*location.GeoResult, error) {
+// FindLocationByAddress prefers an exact name over a prefix match and, when
+// several places share the name, the most populated one. Without a country the
+// place closest to near wins over the more populated one.
+func (db *Database) FindLocationByAddress(ctx context.Context, locIN *location.LocationReadableAddress, near *location.Coordinates) (*location.GeoResult, error) {
 	if locIN == nil {
 		return nil, errors.New("location cannot be nil")
 	}
 
-	args := make([]any, 0, 3)
+	args := make([]any, 0, 5)
 	args = append(args, locIN.CityName)
-	args = append(args, locIN.Country)
 
 	var b strings.Builder
-	b.Grow(195)
+	b.Grow(768)
 	b.WriteString(`
-	SELECT city_name, state, country, lat, lon, local_names
+	SELECT ` + locationColumns + `
     FROM locations
     WHERE to_tsvector('simple', city_name) @@ to_tsquery('simple', $1 || ':*')
-      AND country = $2 
 	`)
+	if locIN.Country != "" {
+		args = append(args, locIN.Country)
+		fmt.Fprintf(&b, "AND country = $%d ", len(args))
+	}
 	if locIN.State != "" {
-		b.WriteString("AND state = $3")
 		args = append(args, locIN.State)
+		fmt.Fprintf(&b, "AND state = $%d ", len(args))
+	}
+	b.WriteString("ORDER BY city_name = $1 DESC, ")
+	if locIN.Country == "" && near != nil {
+		args = append(args, near.Lat, near.Lon)
+		b.WriteString(haversineAt(len(args)-1, len(args)) + ", ")
 	}
-	b.WriteString("LIMIT 1")
+	b.WriteString("population DESC NULLS LAST, id LIMIT 1")
 
-	var loc location.GeoResult
-	var namesRaw []byte
+	return scanLocation(db.Pool.QueryRow(ctx, b.String(), args...))
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SuggestLocations lists the places whose name starts with prefix, the exact
+// name first, then the closest to near when it is set, then the most populated.
+func (db *Database) SuggestLocations(ctx context.Context, prefix, country string, near *location.Coordinates, limit int) ([]location.GeoResult, error) {
+	args := []any{likeEscaper.Replace(prefix) + "%", country, limit, prefix}
+
+	var b strings.Builder
+	b.WriteString(`
+        SELECT ` + locationColumns)
+	if near != nil {
+		args = append(args, near.Lat, near.Lon)
+		b.WriteString(", " + haversineAt(5, 6) + " AS dist")
+	}
+	b.WriteString(`
+        FROM locations
+        WHERE city_name LIKE $1::text
+          AND ($2::text = '' OR country = $2::text)
+        ORDER BY city_name = $4::text DESC, `)
+	if near != nil {
+		b.WriteString("dist, ")
+	}
+	b.WriteString("population DESC NULLS LAST, city_name, id LIMIT $3")
+
+	rows, err := db.Pool.Query(ctx, b.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	if near != nil {
+		return scanLocationRows(rows)
+	}
+	return collectLocations(rows)
+}
+
+// collectLocations reads rows selected with locationColumns alone.
+func collectLocations(rows pgx.Rows) ([]location.GeoResult, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (location.GeoResult, error) {
+		loc, err := scanLocation(row)
+		if err != nil {
+			return location.GeoResult{}, err
+		}
+		return *loc, nil
+	})
+}
+
+// scanLocationRows reads rows selected with locationColumns followed by a distance.
+func scanLocationRows(rows pgx.Rows) ([]location.GeoResult, error) {
+	defer rows.Close()
+
+	results := make([]location.GeoResult, 0, 16)
+	for rows.Next() {
+		var dist float64
+
+		loc, err := scanLocation(rows, &dist)
+		if err != nil {
+			return nil, err
+		}
+		loc.DistanceKm = &dist
+
+		results = append(results, *loc)
+	}
 
-	err := db.Pool.QueryRow(ctx, b.String(), args...).Scan(
-		&loc.CityName, &loc.State, &loc.Country, &loc.Lat, &loc.Lon, &namesRaw,
-	)
+	return results, rows.Err()
+}
+
+func (db *Database) FindLocationsWithin(ctx context.Context, center *location.Coordinates, radiusKm float64, country string, limit, offset int) ([]location.GeoResult, error) {
+	if center == nil {
+		return nil, errors.New("coordinates cannot be nil")
+	}
+
+	latDelta, lonDelta := geo.BoundingBox(*center, radiusKm)
+	query := `
+        SELECT ` + locationColumns + `, dist
+        FROM (
+            SELECT *, ` + haversineSQL + ` AS dist
+            FROM locations
+            WHERE lat BETWEEN ($1::float - $4::float) AND ($1::float + $4::float)
+              AND (abs(lon - $2::float) <= $5::float OR abs(lon - $2::float) >= 360 - $5::float)
+              AND ($6::text = '' OR country = $6::text)
+        ) nearby
+        WHERE dist <= $3::float
+        ORDER BY dist, id
+        LIMIT $7 OFFSET $8`
 
+	rows, err := db.Pool.Query(ctx, query, center.Lat, center.Lon, radiusKm, latDelta, lonDelta, country, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(namesRaw) > 0 {
-		sonic.Unmarshal(namesRaw, &loc.LocalNames)
+	return scanLocationRows(rows)
+}
+
+// FindLocationsInBox returns the rows inside the box ordered by distance from its
+// center. A box with west edge east of its east edge is treated as crossing the antimeridian.
+func (db *Database) FindLocationsInBox(ctx context.Context, sw, ne *location.Coordinates, country string, limit, offset int) ([]location.GeoResult, error) {
+	if sw == nil || ne == nil {
+		return nil, errors.New("coordinates cannot be nil")
 	}
 
-	return &loc, nil
+	center := location.Coordinates{Lat: (sw.Lat + ne.Lat) / 2, Lon: (sw.Lon + ne.Lon) / 2}
+	if sw.Lon > ne.Lon {
+		center.Lon += 180
+		if center.Lon > 180 {
+			center.Lon -= 360
+		}
+	}
+
+	query := `
+        SELECT ` + locationColumns + `, ` + haversineSQL + ` AS dist
+        FROM locations
+        WHERE lat BETWEEN $3::float AND $5::float
+          AND (
+              ($4::float <= $6::float AND lon BETWEEN $4::float AND $6::float)
+              OR ($4::float > $6::float AND (lon >= $4::float OR lon <= $6::float))
+          )
+          AND ($7::text = '' OR country = $7::text)
+        ORDER BY dist, id
+        LIMIT $8 OFFSET $9`
+
+	rows, err := db.Pool.Query(ctx, query, center.Lat, center.Lon, sw.Lat, sw.Lon, ne.Lat, ne.Lon, country, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanLocationRows(rows)
+}
+
+// FindLocationByIP returns the location of the narrowest network holding ip
+// that was fetched within maxAge, together with when it was fetched.
+func (db *Database) FindLocationByIP(ctx context.Context, ip string, maxAge time.Duration) (*location.GeoResult, time.Time, error) {
+	query := `
+        SELECT ` + locationColumnsJoined + `, g.fetched_at
+        FROM ip_geolocation g
+        JOIN locations l ON l.id = g.location_id
+        WHERE g.network >>= $1::inet
+          AND g.fetched_at > NOW() - $2::interval
+        ORDER BY masklen(g.network) DESC
+        LIMIT 1`
+
+	var fetchedAt time.Time
+	loc, err := scanLocation(db.Pool.QueryRow(ctx, query, ip, maxAge), &fetchedAt)
+	return loc, fetchedAt, err
+}
+
+// SaveIPLocation stores the location when it is new and points the address at it.
+func (db *Database) SaveIPLocation(ctx context.Context, ip, source string, loc *location.GeoResult) error {
+	query := saveLocationSQL + `
+        INSERT INTO ip_geolocation (network, location_id, fetched_at, source)
+        SELECT $14::inet, id, NOW(), $15 FROM loc
+        ON CONFLICT (network) DO UPDATE SET
+            location_id = EXCLUDED.location_id,
+            fetched_at = EXCLUDED.fetched_at,
+            source = EXCLUDED.source`
+
+	_, err := db.Pool.Exec(ctx, query, append(saveLocationArgs(loc), ip, source)...)
+	return err
 }
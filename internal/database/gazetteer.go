@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+	"github.com/jackc/pgx/v5"
+)
+
+type GazetteerRow struct {
+	CityName   string
+	State      string
+	Country    string
+	Lat        float64
+	Lon        float64
+	Population int64
+	LocalNames map[string]string
+
+	County       string
+	FeatureClass string
+	Elevation    *int32
+	Timezone     string
+	Source       string
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (db *Database) GazetteerProgress(ctx context.Context, source string) (int64, error) {
+	var done int64
+	err := db.Pool.QueryRow(ctx, `SELECT rows_done FROM gazetteer_imports WHERE source = $1`, source).Scan(&done)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return done, err
+}
+
+func (db *Database) ResetGazetteerProgress(ctx context.Context, source string) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM gazetteer_imports WHERE source = $1`, source)
+	return err
+}
+
+// ImportGazetteerBatch upserts one batch through a COPY into a temporary staging
+// table. The batch and the new progress marker commit in the same transaction,
+// so an interrupted import can resume from rowsDone.
+func (db *Database) ImportGazetteerBatch(ctx context.Context, source string, batch []GazetteerRow, rowsDone int64) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+        CREATE TEMP TABLE gazetteer_staging (
+            city_name TEXT NOT NULL,
+            state TEXT NOT NULL,
+            country TEXT NOT NULL,
+            lat FLOAT NOT NULL,
+            lon FLOAT NOT NULL,
+            population BIGINT NOT NULL,
+            local_names JSONB,
+            county TEXT,
+            feature_class TEXT,
+            elevation INTEGER,
+            timezone TEXT,
+            source TEXT
+        ) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"gazetteer_staging"},
+		[]string{"city_name", "state", "country", "lat", "lon", "population", "local_names", "county", "feature_class", "elevation", "timezone", "source"},
+		pgx.CopyFromSlice(len(batch), func(i int) ([]any, error) {
+			row := &batch[i]
+
+			var names any
+			if len(row.LocalNames) > 0 {
+				names = row.LocalNames
+			}
+
+			return []any{
+				util.CleanQuery(row.CityName), location.CanonicalState(row.Country, row.State), row.Country, row.Lat, row.Lon, row.Population, names,
+				nullIfEmpty(row.County), nullIfEmpty(row.FeatureClass), row.Elevation, nullIfEmpty(row.Timezone), nullIfEmpty(row.Source),
+			}, nil
+		}))
+	if err != nil {
+		return err
+	}
+
+	// a batch can hold several places with the same name, keep the most populated.
+	// Across batches a smaller namesake must not move the row or lower its population.
+	_, err = tx.Exec(ctx, `
+        INSERT INTO locations (city_name, state, country, lat, lon, local_names,
+            county, population, feature_class, elevation, timezone, source)
+        SELECT DISTINCT ON (city_name, state, country) city_name, state, country, lat, lon, local_names,
+            county, NULLIF(population, 0), feature_class, elevation, timezone, source
+        FROM gazetteer_staging
+        ORDER BY city_name, state, country, population DESC
+        ON CONFLICT (city_name, country, state) DO UPDATE SET
+            lat = EXCLUDED.lat,
+            lon = EXCLUDED.lon,
+            local_names = COALESCE(locations.local_names, '{}'::jsonb) || COALESCE(EXCLUDED.local_names, '{}'::jsonb),
+            county = COALESCE(EXCLUDED.county, locations.county),
+            population = COALESCE(EXCLUDED.population, locations.population),
+            feature_class = COALESCE(EXCLUDED.feature_class, locations.feature_class),
+            elevation = COALESCE(EXCLUDED.elevation, locations.elevation),
+            timezone = COALESCE(EXCLUDED.timezone, locations.timezone),
+            source = COALESCE(EXCLUDED.source, locations.source)
+        WHERE COALESCE(EXCLUDED.population, 0) >= COALESCE(locations.population, 0)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+        INSERT INTO gazetteer_imports (source, rows_done, updated_at)
+        VALUES ($1, $2, NOW())
+        ON CONFLICT (source) DO UPDATE SET rows_done = EXCLUDED.rows_done, updated_at = EXCLUDED.updated_at`,
+		source, rowsDone)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+	"github.com/jackc/pgx/v5"
+)
+
+// dataMigrations rewrite rows the schema cannot, each runs once and is recorded
+// in data_migrations. Append new ones, never rename them.
+var dataMigrations = []struct {
+	name string
+	run  func(*Database, context.Context) error
+}{
+	{"canonical_state", (*Database).canonicalizeStates},
+	{"canonical_city_name", (*Database).canonicalizeCityNames},
+}
+
+func (db *Database) runDataMigrations(ctx context.Context) error {
+	for _, m := range dataMigrations {
+		var done bool
+		err := db.Pool.QueryRow(ctx, `SELECT true FROM data_migrations WHERE name = $1`, m.name).Scan(&done)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		slog.Info("running data migration", "name", m.name)
+		if err := m.run(db, ctx); err != nil {
+			return err
+		}
+		if _, err := db.Pool.Exec(ctx, `INSERT INTO data_migrations (name) VALUES ($1)`, m.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalizeStates rewrites states stored before location.CanonicalState
+// with the provider's spelling, "CA" or "Kalifornien" for California. A row
+// whose canonical twin already exists is merged into it.
+func (db *Database) canonicalizeStates(ctx context.Context) error {
+	rows, err := db.Pool.Query(ctx, `SELECT DISTINCT country, state FROM locations WHERE state IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	type pair struct{ country, state string }
+	pairs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pair, error) {
+		var p pair
+		err := row.Scan(&p.country, &p.state)
+		return p, err
+	})
+	if err != nil {
+		return err
+	}
+
+	var renamed, merged int64
+	for _, p := range pairs {
+		canonical := location.CanonicalState(p.country, p.state)
+		if canonical == p.state || canonical == "" {
+			continue
+		}
+
+		twins, err := db.Pool.Query(ctx, `
+            SELECT o.id, l.id
+            FROM locations l
+            JOIN locations o ON o.city_name = l.city_name AND o.country = l.country AND o.state = $3
+            WHERE l.country = $1 AND l.state = $2`, p.country, p.state, canonical)
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(twins, func(row pgx.CollectableRow) ([2]int64, error) {
+			var id [2]int64
+			err := row.Scan(&id[0], &id[1])
+			return id, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := db.MergeLocations(ctx, id[0], []int64{id[1]}); err != nil {
+				return err
+			}
+			merged++
+		}
+
+		tag, err := db.Pool.Exec(ctx, `UPDATE locations SET state = $3 WHERE country = $1 AND state = $2`,
+			p.country, p.state, canonical)
+		if err != nil {
+			return err
+		}
+		renamed += tag.RowsAffected()
+	}
+
+	slog.Info("canonicalized stored states", "renamedRows", renamed, "mergedRows", merged)
+	return nil
+}
+
+// canonicalizeCityNames re-cleans city names stored under older CleanQuery
+// rules, "łodz" where queries now clean to "lodz". Run it with the
+// romanization the server uses. A row whose cleaned twin already exists in
+// the same country and state is merged into it.
+func (db *Database) canonicalizeCityNames(ctx context.Context) error {
+	rows, err := db.Pool.Query(ctx, `SELECT DISTINCT city_name FROM locations`)
+	if err != nil {
+		return err
+	}
+	type rename struct{ from, to string }
+	var renames []rename
+	var name string
+	_, err = pgx.ForEachRow(rows, []any{&name}, func() error {
+		if cleaned := util.CleanQuery(name); cleaned != name && cleaned != "" {
+			renames = append(renames, rename{name, cleaned})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var renamed, merged int64
+	for _, n := range renames {
+		twins, err := db.Pool.Query(ctx, `
+            SELECT o.id, l.id
+            FROM locations l
+            JOIN locations o ON o.city_name = $2 AND o.country = l.country AND o.state IS NOT DISTINCT FROM l.state
+            WHERE l.city_name = $1`, n.from, n.to)
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(twins, func(row pgx.CollectableRow) ([2]int64, error) {
+			var id [2]int64
+			err := row.Scan(&id[0], &id[1])
+			return id, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := db.MergeLocations(ctx, id[0], []int64{id[1]}); err != nil {
+				return err
+			}
+			merged++
+		}
+
+		tag, err := db.Pool.Exec(ctx, `UPDATE locations SET city_name = $2 WHERE city_name = $1`, n.from, n.to)
+		if err != nil {
+			return err
+		}
+		renamed += tag.RowsAffected()
+	}
+
+	slog.Info("canonicalized stored city names", "renamedRows", renamed, "mergedRows", merged)
+	return nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"context"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/bytedance/sonic"
+)
+
+// FindLocationByPostalCode expects the code normalized by location.NormalizePostalCode.
+func (db *Database) FindLocationByPostalCode(ctx context.Context, country, code string) (*location.GeoResult, error) {
+	query := `
+        SELECT l.id, l.city_name, COALESCE(l.state, ''), l.country, l.lat, l.lon, l.local_names
+        FROM postal_codes p
+        JOIN locations l ON l.id = p.location_id
+        WHERE p.country = $1 AND p.postal_code = $2`
+
+	var loc location.GeoResult
+	var namesRaw []byte
+
+	err := db.Pool.QueryRow(ctx, query, country, code).Scan(
+		&loc.ID, &loc.CityName, &loc.State, &loc.Country, &loc.Lat, &loc.Lon, &namesRaw,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(namesRaw) > 0 {
+		sonic.Unmarshal(namesRaw, &loc.LocalNames)
+	}
+	loc.PostalCode = code
+
+	return &loc, nil
+}
+
+// SavePostalCode points the code at the location, replacing an older mapping.
+func (db *Database) SavePostalCode(ctx context.Context, country, code string, locationID int64) error {
+	query := `
+        INSERT INTO postal_codes (country, postal_code, location_id, fetched_at)
+        VALUES ($1, $2, $3, NOW())
+        ON CONFLICT (country, postal_code) DO UPDATE SET
+            location_id = EXCLUDED.location_id,
+            fetched_at = EXCLUDED.fetched_at`
+
+	_, err := db.Pool.Exec(ctx, query, country, code, locationID)
+	return err
+}
@@ -18,6 +18,8 @@ CREATE INDEX IF NOT EXISTS idx_locations_name_pattern ON locations (city_name va
 
 CREATE INDEX IF NOT EXISTS idx_locations_fts_vector ON locations USING GIN (city_search_vector);
 
+CREATE INDEX IF NOT EXISTS idx_locations_lat_lon ON locations (lat, lon);
+
 CREATE TABLE IF NOT EXISTS weather_current_cache (
     location_id INTEGER PRIMARY KEY REFERENCES locations(id) ON DELETE CASCADE,
     full_data JSONB,
@@ -38,3 +40,47 @@ CREATE INDEX IF NOT EXISTS idx_current_loc ON weather_current_cache (location_id
 
 CREATE INDEX IF NOT EXISTS idx_history_loc_date ON weather_history (location_id, recorded_date DESC);
 CREATE INDEX IF NOT EXISTS idx_history_raw_data ON weather_history USING GIN (raw_data);
+
+CREATE TABLE IF NOT EXISTS gazetteer_imports (
+    source TEXT PRIMARY KEY,
+    rows_done BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS ip_geolocation (
+    network CIDR PRIMARY KEY,
+    location_id INTEGER NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    source TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_geolocation_network ON ip_geolocation USING GIST (network inet_ops);
+
+CREATE TABLE IF NOT EXISTS postal_codes (
+    country TEXT NOT NULL,
+    postal_code TEXT NOT NULL,
+    location_id INTEGER NOT NULL REFERENCES locations(id) ON DELETE CASCADE,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (country, postal_code)
+);
+
+CREATE INDEX IF NOT EXISTS idx_postal_codes_location ON postal_codes (location_id);
+
+ALTER TABLE locations
+    ADD COLUMN IF NOT EXISTS county TEXT,
+    ADD COLUMN IF NOT EXISTS postal_code TEXT,
+    ADD COLUMN IF NOT EXISTS population BIGINT,
+    ADD COLUMN IF NOT EXISTS feature_class TEXT,
+    ADD COLUMN IF NOT EXISTS elevation INTEGER,
+    ADD COLUMN IF NOT EXISTS timezone TEXT,
+    ADD COLUMN IF NOT EXISTS source TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_locations_country_state_population
+ON locations (country, state, population DESC NULLS LAST, city_name, id);
+CREATE INDEX IF NOT EXISTS idx_locations_country_population
+ON locations (country, population DESC NULLS LAST, city_name, id);
+
+CREATE TABLE IF NOT EXISTS data_migrations (
+    name TEXT PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
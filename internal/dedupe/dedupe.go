@@ -0,0 +1,135 @@
+package dedupe
+
+import (
+	"context"
+	"slices"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+type Cluster struct {
+	Keep          *location.GeoResult   `json:"keep"`
+	Duplicates    []*location.GeoResult `json:"duplicates"`
+	MaxDistanceKm float64               `json:"max_distance_km"`
+}
+
+// MergeFunc folds sourceIDs into targetID, see Database.MergeLocations.
+type MergeFunc func(ctx context.Context, targetID int64, sourceIDs []int64) error
+
+// Find groups rows with the same normalized name and country that lie within
+// thresholdKm of each other (single linkage, so chains of close rows join up).
+func Find(ctx context.Context, db *database.Database, country string, thresholdKm float64) ([]Cluster, error) {
+	candidates, err := db.DuplicateCandidates(ctx, country)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []Cluster
+	for start := 0; start < len(candidates); {
+		end := start + 1
+		for end < len(candidates) &&
+			candidates[end].NormalizedName == candidates[start].NormalizedName &&
+			candidates[end].Country == candidates[start].Country {
+			end++
+		}
+
+		clusters = append(clusters, clusterGroup(candidates[start:end], thresholdKm)...)
+		start = end
+	}
+	return clusters, nil
+}
+
+func clusterGroup(group []database.DuplicateCandidate, thresholdKm float64) []Cluster {
+	// union-find over the (small) name group
+	parent := make([]int, len(group))
+	for i := range parent {
+		parent[i] = i
+	}
+	root := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	for i := range group {
+		for j := i + 1; j < len(group); j++ {
+			if geo.Haversine(group[i].Coordinates, group[j].Coordinates) <= thresholdKm {
+				parent[root(j)] = root(i)
+			}
+		}
+	}
+
+	members := make(map[int][]*location.GeoResult)
+	for i := range group {
+		r := root(i)
+		members[r] = append(members[r], &group[i].GeoResult)
+	}
+
+	var clusters []Cluster
+	for i := range group {
+		locs := members[i]
+		if len(locs) < 2 {
+			continue
+		}
+
+		keep := slices.MaxFunc(locs, preferKeep)
+		c := Cluster{Keep: keep}
+		for _, loc := range locs {
+			if loc == keep {
+				continue
+			}
+			c.Duplicates = append(c.Duplicates, loc)
+			c.MaxDistanceKm = max(c.MaxDistanceKm, geo.Haversine(keep.Coordinates, loc.Coordinates))
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// preferKeep ranks the row that survives a merge: one with a state beats one
+// without, then the more populated one, the one with more local names, the oldest id.
+func preferKeep(a, b *location.GeoResult) int {
+	if (a.State != "") != (b.State != "") {
+		if a.State != "" {
+			return 1
+		}
+		return -1
+	}
+	if a.Population != b.Population {
+		if a.Population > b.Population {
+			return 1
+		}
+		return -1
+	}
+	if len(a.LocalNames) != len(b.LocalNames) {
+		return len(a.LocalNames) - len(b.LocalNames)
+	}
+	if a.ID < b.ID {
+		return 1
+	}
+	if a.ID > b.ID {
+		return -1
+	}
+	return 0
+}
+
+// MergeAll merges every cluster into its kept row and returns how many rows were removed.
+func MergeAll(ctx context.Context, clusters []Cluster, merge MergeFunc) (int, error) {
+	removed := 0
+	for _, c := range clusters {
+		ids := make([]int64, len(c.Duplicates))
+		for i, d := range c.Duplicates {
+			ids[i] = d.ID
+		}
+
+		if err := merge(ctx, c.Keep.ID, ids); err != nil {
+			return removed, err
+		}
+		removed += len(ids)
+	}
+	return removed, nil
+}
@@ -0,0 +1,171 @@
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+func candidate(id int64, lat, lon float64) database.DuplicateCandidate {
+	var c database.DuplicateCandidate
+	c.ID = id
+	c.Lat, c.Lon = lat, lon
+	return c
+}
+
+func clusterIDs(clusters []Cluster) [][]int64 {
+	var ids [][]int64
+	for _, c := range clusters {
+		group := []int64{c.Keep.ID}
+		for _, d := range c.Duplicates {
+			group = append(group, d.ID)
+		}
+		slices.Sort(group[1:])
+		ids = append(ids, group)
+	}
+	return ids
+}
+
+func TestClusterGroup(t *testing.T) {
+	// on the equator 0.03 degrees of longitude are about 3.3 km
+	tests := []struct {
+		name     string
+		group    []database.DuplicateCandidate
+		expected [][]int64
+	}{
+		{
+			"Close Pair",
+			[]database.DuplicateCandidate{candidate(1, 0, 0), candidate(2, 0, 0.03)},
+			[][]int64{{1, 2}},
+		},
+		{
+			"Too Far Apart",
+			[]database.DuplicateCandidate{candidate(1, 0, 0), candidate(2, 0, 0.05)},
+			nil,
+		},
+		{
+			"Chain Joins Up",
+			[]database.DuplicateCandidate{candidate(1, 0, 0), candidate(2, 0, 0.06), candidate(3, 0, 0.03)},
+			[][]int64{{1, 2, 3}},
+		},
+		{
+			"Chain Joined From Its Far End",
+			[]database.DuplicateCandidate{
+				candidate(1, 0, 0), candidate(2, 0, 0.09), candidate(3, 0, 0.06), candidate(4, 0, 0.03),
+			},
+			[][]int64{{1, 2, 3, 4}},
+		},
+		{
+			"Two Clusters And A Loner",
+			[]database.DuplicateCandidate{
+				candidate(1, 0, 0), candidate(2, 10, 10), candidate(3, 0, 0.02),
+				candidate(4, 10, 10.02), candidate(5, -20, 0),
+			},
+			[][]int64{{1, 3}, {2, 4}},
+		},
+		{"Single Row", []database.DuplicateCandidate{candidate(1, 0, 0)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clusterIDs(clusterGroup(tt.group, 4))
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("clusterGroup() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClusterGroupKeepAndDistance(t *testing.T) {
+	group := []database.DuplicateCandidate{candidate(1, 0, 0), candidate(2, 0, 0.03), candidate(3, 0, 0.06)}
+	group[1].State = "Somewhere"
+
+	clusters := clusterGroup(group, 4)
+	if len(clusters) != 1 {
+		t.Fatalf("clusterGroup() returned %d clusters, want 1", len(clusters))
+	}
+	c := clusters[0]
+	if c.Keep.ID != 2 {
+		t.Errorf("Keep = %d, want 2, the only row with a state", c.Keep.ID)
+	}
+	// both duplicates are about 3.3 km from the kept middle row
+	if c.MaxDistanceKm < 3.2 || c.MaxDistanceKm > 3.4 {
+		t.Errorf("MaxDistanceKm = %f, want about 3.3", c.MaxDistanceKm)
+	}
+}
+
+func TestPreferKeep(t *testing.T) {
+	loc := func(id int64, state string, population int64, localNames int) *location.GeoResult {
+		g := &location.GeoResult{ID: id, LocalNames: make(map[string]string)}
+		g.State = state
+		g.Population = population
+		for i := range localNames {
+			g.LocalNames[string(rune('a'+i))] = "x"
+		}
+		return g
+	}
+
+	tests := []struct {
+		name     string
+		a, b     *location.GeoResult
+		expected int
+	}{
+		{"State Beats No State", loc(2, "CA", 0, 0), loc(1, "", 1000, 5), 1},
+		{"No State Loses", loc(1, "", 1000, 5), loc(2, "CA", 0, 0), -1},
+		{"More Populated", loc(2, "CA", 500, 0), loc(1, "CA", 100, 5), 1},
+		{"Less Populated", loc(1, "", 100, 5), loc(2, "", 500, 0), -1},
+		{"More Local Names", loc(2, "", 100, 3), loc(1, "", 100, 1), 1},
+		{"Fewer Local Names", loc(1, "", 100, 1), loc(2, "", 100, 3), -1},
+		{"Older ID", loc(1, "CA", 100, 1), loc(2, "CA", 100, 1), 1},
+		{"Newer ID", loc(2, "CA", 100, 1), loc(1, "CA", 100, 1), -1},
+		{"Same Row", loc(1, "CA", 100, 1), loc(1, "CA", 100, 1), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preferKeep(tt.a, tt.b)
+			if got > 0 {
+				got = 1
+			} else if got < 0 {
+				got = -1
+			}
+			if got != tt.expected {
+				t.Errorf("preferKeep() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeAll(t *testing.T) {
+	clusters := clusterGroup([]database.DuplicateCandidate{
+		candidate(1, 0, 0), candidate(2, 0, 0.01), candidate(3, 0, 0.02), candidate(4, 10, 10), candidate(5, 10, 10),
+	}, 4)
+
+	merged := make(map[int64][]int64)
+	removed, err := MergeAll(context.Background(), clusters, func(ctx context.Context, targetID int64, sourceIDs []int64) error {
+		merged[targetID] = slices.Sorted(slices.Values(sourceIDs))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("MergeAll() error = %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("MergeAll() removed %d, want 3", removed)
+	}
+	if want := map[int64][]int64{1: {2, 3}, 4: {5}}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("MergeAll() merged %v, want %v", merged, want)
+	}
+
+	failure := errors.New("merge failed")
+	removed, err = MergeAll(context.Background(), clusters, func(ctx context.Context, targetID int64, sourceIDs []int64) error {
+		return failure
+	})
+	if !errors.Is(err, failure) || removed != 0 {
+		t.Errorf("MergeAll() = %d, %v, want 0, %v", removed, err, failure)
+	}
+}
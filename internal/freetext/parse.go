@@ -0,0 +1,235 @@
+// Package freetext turns a single search box value such as "Springfield, IL, US",
+// "10115 Berlin DE" or "50.08,14.43" into structured location input.
+package freetext
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+)
+
+const (
+	KindCoordinates = "coordinates"
+	KindAddress     = "address"
+	KindPostalCode  = "postal_code"
+)
+
+type Interpretation struct {
+	Kind       string   `json:"kind"`
+	City       string   `json:"city,omitempty"`
+	State      string   `json:"state,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	PostalCode string   `json:"postal_code,omitempty"`
+	Lat        *float64 `json:"lat,omitempty"`
+	Lon        *float64 `json:"lon,omitempty"`
+}
+
+// MaxQueryLength bounds a search box value, no address needs more.
+const MaxQueryLength = 256
+
+var (
+	ErrEmpty   = errors.New("query is empty")
+	ErrTooLong = fmt.Errorf("query is longer than %d bytes", MaxQueryLength)
+)
+
+var (
+	// 12345, 1234, 123456, US ZIP+4 and the Canadian A1A1A1 written as one token
+	postalSingle = regexp.MustCompile(`^(?:\d{4,6}|\d{5}-\d{4}|[A-Za-z]\d[A-Za-z]\d[A-Za-z]\d)$`)
+	// "160 00" (CZ, SK, SE, GR), "K1A 0B1" (CA) and UK "SW1A 1AA"
+	postalPair = regexp.MustCompile(`^(?:\d{3} \d{2}|[A-Za-z]\d[A-Za-z] \d[A-Za-z]\d|[A-Za-z]{1,2}\d[A-Za-z\d]? \d[A-Za-z]{2})$`)
+	digitsOnly = regexp.MustCompile(`^\d+$`)
+	// the district number of "Praha 6" or "Wien 3"
+	districtNumber = regexp.MustCompile(`^\d{1,3}$`)
+)
+
+// maxCoordinateFields is the longest whitespace split pair, "50 4 31.8 N 14 26 16.08 E".
+const maxCoordinateFields = 8
+
+// parseCoordinates reads a latitude and longitude in any format of
+// geo.ParseLatitude, split by a semicolon, a comma or whitespace.
+func parseCoordinates(q string) (lat, lon float64, ok bool) {
+	for _, sep := range []string{";", ","} {
+		if a, b, found := strings.Cut(q, sep); found {
+			return parseCoordinatePair(a, b)
+		}
+	}
+
+	// "50 4 31.8 N 14 26 16.08 E" has no single place to split, so every
+	// boundary between words is tried. Three bare numbers are left alone.
+	fields := strings.Fields(q)
+	if len(fields) > maxCoordinateFields || len(fields) > 2 && !strings.ContainsAny(q, "°'\"′″NSEWnsew") {
+		return 0, 0, false
+	}
+	for i := 1; i < len(fields); i++ {
+		if lat, lon, ok := parseCoordinatePair(strings.Join(fields[:i], " "), strings.Join(fields[i:], " ")); ok {
+			return lat, lon, true
+		}
+	}
+	return 0, 0, false
+}
+
+func parseCoordinatePair(a, b string) (lat, lon float64, ok bool) {
+	lat, err := geo.ParseLatitude(a)
+	if err != nil {
+		return 0, 0, false
+	}
+	lon, err = geo.ParseLongitude(b)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+type word struct {
+	text string
+	part int
+}
+
+func Parse(q string) (Interpretation, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return Interpretation{}, ErrEmpty
+	}
+	if len(q) > MaxQueryLength {
+		return Interpretation{}, ErrTooLong
+	}
+
+	if lat, lon, ok := parseCoordinates(q); ok {
+		return Interpretation{Kind: KindCoordinates, Lat: &lat, Lon: &lon}, nil
+	}
+
+	var words []word
+	parts := 0
+	for part := range strings.SplitSeq(q, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, f := range fields {
+			words = append(words, word{text: f, part: parts})
+		}
+		parts++
+	}
+
+	var in Interpretation
+
+	// the country is the trailing words of the last comma part, "US" or "Czech Republic"
+	if n, c := matchSuffix(words, 4, func(s string) (string, bool) {
+		if digitsOnly.MatchString(s) {
+			return "", false // numeric codes would swallow postal codes
+		}
+		c, ok := iso3166.LookupCountry(s)
+		if !ok {
+			return "", false
+		}
+		return c.Alpha2, true
+	}); n > 0 {
+		in.Country = c
+		words = words[:len(words)-n]
+	}
+
+	// the postal code goes first, so a city state such as Berlin is left as the
+	// city and not taken for the subdivision in "10115 Berlin, Germany"
+	words, in.PostalCode = extractPostalCode(words)
+
+	if n, sub := matchSuffix(words, 3, func(s string) (string, bool) {
+		return lookupSubdivision(&in, s)
+	}); n > 0 {
+		in.State = sub
+		words = words[:len(words)-n]
+	} else if len(words) > 0 && words[len(words)-1].part > words[0].part {
+		// "Frydek-Mistek, Moravskoslezsky kraj, CZ": an unknown middle part is the state
+		last := words[len(words)-1].part
+		i := len(words)
+		for i > 0 && words[i-1].part == last {
+			i--
+		}
+		in.State = joinWords(words[i:])
+		words = words[:i]
+	}
+
+	// stored places are whole cities, "Praha 6" is looked up as Praha
+	for len(words) > 1 && districtNumber.MatchString(words[len(words)-1].text) {
+		words = words[:len(words)-1]
+	}
+	in.City = joinWords(words)
+
+	switch {
+	case in.City != "":
+		in.Kind = KindAddress
+	case in.PostalCode != "":
+		in.Kind = KindPostalCode
+	default:
+		return Interpretation{}, errors.New("query names no city or postal code")
+	}
+	return in, nil
+}
+
+func lookupSubdivision(in *Interpretation, s string) (string, bool) {
+	if in.Country != "" {
+		sub, ok := iso3166.LookupSubdivision(in.Country, s)
+		if !ok {
+			return "", false
+		}
+		return sub.Name, true
+	}
+
+	// without a country only the well known US and CA codes are recognized
+	if len(s) != 2 || strings.ToUpper(s) != s {
+		return "", false
+	}
+	for _, country := range [...]string{"US", "CA"} {
+		if sub, ok := iso3166.LookupSubdivision(country, s); ok {
+			in.Country = country
+			return sub.Name, true
+		}
+	}
+	return "", false
+}
+
+// matchSuffix tries the last 1..maxWords words of the final comma part, longest
+// first, and always leaves at least one word in front of the match.
+func matchSuffix(words []word, maxWords int, match func(string) (string, bool)) (int, string) {
+	if len(words) < 2 {
+		return 0, ""
+	}
+	last := words[len(words)-1].part
+
+	for n := min(maxWords, len(words)-1); n > 0; n-- {
+		suffix := words[len(words)-n:]
+		if suffix[0].part != last {
+			continue
+		}
+		if v, ok := match(joinWords(suffix)); ok {
+			return n, v
+		}
+	}
+	return 0, ""
+}
+
+func extractPostalCode(words []word) ([]word, string) {
+	for i := range words {
+		if i+1 < len(words) && words[i].part == words[i+1].part {
+			pair := words[i].text + " " + words[i+1].text
+			if postalPair.MatchString(pair) {
+				return append(words[:i:i], words[i+2:]...), strings.ToUpper(pair)
+			}
+		}
+		if postalSingle.MatchString(words[i].text) {
+			return append(words[:i:i], words[i+1:]...), strings.ToUpper(words[i].text)
+		}
+	}
+	return words, ""
+}
+
+func joinWords(words []word) string {
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.text
+	}
+	return strings.Join(texts, " ")
+}
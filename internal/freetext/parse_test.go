@@ -0,0 +1,204 @@
+package freetext
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+)
+
+func ptr(f float64) *float64 { return &f }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected Interpretation
+	}{
+		{
+			name:     "City State Country",
+			query:    "Springfield, IL, US",
+			expected: Interpretation{Kind: KindAddress, City: "Springfield", State: "Illinois", Country: "US"},
+		},
+		{
+			name:     "District Number And Code",
+			query:    "Praha 6 CZ",
+			expected: Interpretation{Kind: KindAddress, City: "Praha", Country: "CZ"},
+		},
+		{
+			name:     "District Number Without Country",
+			query:    "Praha 10",
+			expected: Interpretation{Kind: KindAddress, City: "Praha"},
+		},
+		{
+			name:     "District Number Before The State",
+			query:    "Wien 3, Wien, AT",
+			expected: Interpretation{Kind: KindAddress, City: "Wien", State: "Wien", Country: "AT"},
+		},
+		{
+			name:     "Country Name",
+			query:    "Frydek-Mistek, Czechia",
+			expected: Interpretation{Kind: KindAddress, City: "Frydek-Mistek", Country: "CZ"},
+		},
+		{
+			name:     "Alpha3 Code",
+			query:    "Berlin DEU",
+			expected: Interpretation{Kind: KindAddress, City: "Berlin", Country: "DE"},
+		},
+		{
+			name:     "Postal Code With City",
+			query:    "10115 Berlin, Germany",
+			expected: Interpretation{Kind: KindAddress, City: "Berlin", Country: "DE", PostalCode: "10115"},
+		},
+		{
+			name:     "City State With Its Subdivision",
+			query:    "Berlin, Berlin, Germany",
+			expected: Interpretation{Kind: KindAddress, City: "Berlin", State: "Berlin", Country: "DE"},
+		},
+		{
+			name:     "Postal Code Before The State",
+			query:    "Springfield, IL 62701, US",
+			expected: Interpretation{Kind: KindAddress, City: "Springfield", State: "Illinois", Country: "US", PostalCode: "62701"},
+		},
+		{
+			name:     "Split Postal Code Only",
+			query:    "160 00 CZ",
+			expected: Interpretation{Kind: KindPostalCode, Country: "CZ", PostalCode: "160 00"},
+		},
+		{
+			name:     "Coordinates",
+			query:    "50.08,14.43",
+			expected: Interpretation{Kind: KindCoordinates, Lat: ptr(50.08), Lon: ptr(14.43)},
+		},
+		{
+			name:     "Coordinates On The Equator",
+			query:    "0 0",
+			expected: Interpretation{Kind: KindCoordinates, Lat: ptr(0), Lon: ptr(0)},
+		},
+		{
+			name:     "Coordinates With Hemispheres",
+			query:    "33.5S 70.5W",
+			expected: Interpretation{Kind: KindCoordinates, Lat: ptr(-33.5), Lon: ptr(-70.5)},
+		},
+		{
+			name:     "Coordinates In Degrees And Minutes",
+			query:    "50 30 N 14 15 E",
+			expected: Interpretation{Kind: KindCoordinates, Lat: ptr(50.5), Lon: ptr(14.25)},
+		},
+		{
+			name:     "Subdivision Without Accents",
+			query:    "Frydek-Mistek, Moravskoslezsky kraj, CZ",
+			expected: Interpretation{Kind: KindAddress, City: "Frydek-Mistek", State: "Moravskoslezský kraj", Country: "CZ"},
+		},
+		{
+			name:     "Translated Subdivision",
+			query:    "Munich, Bavaria, Germany",
+			expected: Interpretation{Kind: KindAddress, City: "Munich", State: "Bayern", Country: "DE"},
+		},
+		{
+			name:     "Unknown State Part",
+			query:    "Springfield, Nowhere, US",
+			expected: Interpretation{Kind: KindAddress, City: "Springfield", State: "Nowhere", Country: "US"},
+		},
+		{
+			name:     "Lone Word Stays A City",
+			query:    "Georgia",
+			expected: Interpretation{Kind: KindAddress, City: "Georgia"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.query, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseLongInput(t *testing.T) {
+	long := strings.Repeat("1 N ", 4000)
+
+	start := time.Now()
+	if _, err := Parse(long); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Parse() of a 16 KB query error = %v, want %v", err, ErrTooLong)
+	}
+	// many words must not try every split
+	if _, _, ok := parseCoordinates(strings.TrimSpace(long)); ok {
+		t.Error("parseCoordinates() read coordinates from a 16 KB query")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("long queries took %v", elapsed)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add("Springfield, IL, US")
+	f.Add("160 00 Praha, CZ")
+
+	f.Add("50 4 31.8 N 14 26 16.08 E")
+	f.Add("Ostrava")
+
+	// go test -fuzz=FuzzParse -fuzztime=30s ./internal/freetext
+	f.Fuzz(func(t *testing.T, q string) {
+		in, err := Parse(q)
+		if err != nil {
+			if isPlainCityName(q) {
+				t.Errorf("Parse(%q) error = %v, want a city", q, err)
+			}
+			return
+		}
+
+		switch in.Kind {
+		case KindCoordinates:
+			if in.Lat == nil || in.Lon == nil {
+				t.Fatalf("Parse(%q) = coordinates without lat or lon", q)
+			}
+			if *in.Lat < -90 || *in.Lat > 90 || *in.Lon < -180 || *in.Lon > 180 {
+				t.Errorf("Parse(%q) = %f, %f, out of range", q, *in.Lat, *in.Lon)
+			}
+		case KindAddress:
+			if in.City == "" {
+				t.Errorf("Parse(%q) = address without a city", q)
+			}
+		case KindPostalCode:
+			if in.PostalCode == "" || in.City != "" {
+				t.Errorf("Parse(%q) = %+v, want only a postal code", q, in)
+			}
+		default:
+			t.Fatalf("Parse(%q) kind = %q", q, in.Kind)
+		}
+		if in.Kind != KindCoordinates && (in.Lat != nil || in.Lon != nil) {
+			t.Errorf("Parse(%q) = %s with coordinates", q, in.Kind)
+		}
+		if isPlainCityName(q) && (in.Kind != KindAddress || in.City != strings.TrimSpace(q)) {
+			t.Errorf("Parse(%q) = %+v, want it as the city", q, in)
+		}
+	})
+}
+
+// isPlainCityName reports a single ASCII word that names no country or US and
+// CA subdivision, nothing but a city can be made of it.
+func isPlainCityName(q string) bool {
+	q = strings.TrimSpace(q)
+	if q == "" || len(q) > MaxQueryLength {
+		return false
+	}
+	for _, r := range q {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	if _, ok := iso3166.LookupCountry(q); ok {
+		return false
+	}
+	_, ok := lookupSubdivision(&Interpretation{}, q)
+	return !ok
+}
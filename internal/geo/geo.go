@@ -0,0 +1,157 @@
+package geo
+
+import (
+	"errors"
+	"math"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+const EarthRadiusKm = 6371.0088
+
+// WGS-84 ellipsoid used by Vincenty.
+const (
+	wgs84A = 6378.137
+	wgs84F = 1 / 298.257223563
+	wgs84B = wgs84A * (1 - wgs84F)
+)
+
+var ErrNoConvergence = errors.New("vincenty formula failed to converge")
+
+func toRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func toDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func normalizeLon(lon float64) float64 {
+	return math.Mod(lon+540, 360) - 180
+}
+
+func normalizeBearing(deg float64) float64 {
+	return math.Mod(deg+360, 360)
+}
+
+func Haversine(a, b location.Coordinates) float64 {
+	dLat := toRad(b.Lat - a.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Lat))*math.Cos(toRad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * EarthRadiusKm * math.Asin(math.Sqrt(min(h, 1)))
+}
+
+// Vincenty returns the ellipsoidal distance in km. It fails for nearly
+// antipodal points, callers should fall back to Haversine.
+func Vincenty(a, b location.Coordinates) (float64, error) {
+	L := toRad(b.Lon - a.Lon)
+	U1 := math.Atan((1 - wgs84F) * math.Tan(toRad(a.Lat)))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(toRad(b.Lat)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+
+	for i := 0; ; i++ {
+		if i == 200 {
+			return 0, ErrNoConvergence
+		}
+
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0, nil
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		} else {
+			cos2SigmaM = 0 // equatorial line
+		}
+
+		C := wgs84F / 16 * cosSqAlpha * (4 + wgs84F*(4-3*cosSqAlpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+		if math.Abs(lambda-prev) < 1e-12 {
+			break
+		}
+	}
+
+	uSq := cosSqAlpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return wgs84B * A * (sigma - deltaSigma), nil
+}
+
+// InitialBearing is the compass bearing in degrees when leaving a towards b.
+func InitialBearing(a, b location.Coordinates) float64 {
+	lat1, lat2 := toRad(a.Lat), toRad(b.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+
+	return normalizeBearing(toDeg(math.Atan2(y, x)))
+}
+
+// FinalBearing is the compass bearing in degrees when arriving at b from a.
+func FinalBearing(a, b location.Coordinates) float64 {
+	return normalizeBearing(InitialBearing(b, a) + 180)
+}
+
+func Midpoint(a, b location.Coordinates) location.Coordinates {
+	lat1, lon1 := toRad(a.Lat), toRad(a.Lon)
+	lat2 := toRad(b.Lat)
+	dLon := toRad(b.Lon - a.Lon)
+
+	bx := math.Cos(lat2) * math.Cos(dLon)
+	by := math.Cos(lat2) * math.Sin(dLon)
+
+	lat := math.Atan2(math.Sin(lat1)+math.Sin(lat2), math.Hypot(math.Cos(lat1)+bx, by))
+	lon := lon1 + math.Atan2(by, math.Cos(lat1)+bx)
+
+	return location.Coordinates{Lat: toDeg(lat), Lon: normalizeLon(toDeg(lon))}
+}
+
+// Destination travels distanceKm from start along the given initial bearing.
+func Destination(start location.Coordinates, bearing, distanceKm float64) location.Coordinates {
+	lat1, lon1 := toRad(start.Lat), toRad(start.Lon)
+	brng := toRad(bearing)
+	d := distanceKm / EarthRadiusKm
+
+	lat := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(brng))
+	lon := lon1 + math.Atan2(math.Sin(brng)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat))
+
+	return location.Coordinates{Lat: toDeg(lat), Lon: normalizeLon(toDeg(lon))}
+}
+
+// BoundingBox returns the lat/lon half-widths in degrees of a box that fully
+// contains a circle of radiusKm around c. The lon delta is capped at 180 once
+// the circle reaches a pole.
+func BoundingBox(c location.Coordinates, radiusKm float64) (latDelta, lonDelta float64) {
+	latDelta = radiusKm / EarthRadiusKm * 180 / math.Pi
+
+	if math.Abs(c.Lat)+latDelta >= 90 {
+		return latDelta, 180
+	}
+
+	s := math.Sin(radiusKm/EarthRadiusKm) / math.Cos(toRad(c.Lat))
+	if s >= 1 {
+		return latDelta, 180
+	}
+
+	return latDelta, math.Asin(s) * 180 / math.Pi
+}
@@ -0,0 +1,130 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+var (
+	prague = location.Coordinates{Lat: 50.0755, Lon: 14.4378}
+	berlin = location.Coordinates{Lat: 52.5200, Lon: 13.4050}
+)
+
+func almostEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestDistances(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     location.Coordinates
+		expected float64
+	}{
+		{"Prague-Berlin", prague, berlin, 281.0},
+		{"Same Point", prague, prague, 0},
+		{"Across Antimeridian", location.Coordinates{Lat: 0, Lon: 179.5}, location.Coordinates{Lat: 0, Lon: -179.5}, 111.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Haversine(tt.a, tt.b); !almostEqual(got, tt.expected, 1) {
+				t.Errorf("Haversine() = %v, want ~%v", got, tt.expected)
+			}
+			got, err := Vincenty(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("Vincenty() error = %v", err)
+			}
+			if !almostEqual(got, tt.expected, 1.5) {
+				t.Errorf("Vincenty() = %v, want ~%v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBearingsAndDestination(t *testing.T) {
+	initial := InitialBearing(prague, berlin)
+	if !almostEqual(initial, 345.6, 0.5) {
+		t.Errorf("InitialBearing() = %v, want ~345.6", initial)
+	}
+
+	dest := Destination(prague, initial, Haversine(prague, berlin))
+	if !almostEqual(dest.Lat, berlin.Lat, 1e-6) || !almostEqual(dest.Lon, berlin.Lon, 1e-6) {
+		t.Errorf("Destination() = %v, want %v", dest, berlin)
+	}
+
+	mid := Midpoint(prague, berlin)
+	if d1, d2 := Haversine(prague, mid), Haversine(mid, berlin); !almostEqual(d1, d2, 1e-6) {
+		t.Errorf("Midpoint() is not equidistant: %v vs %v", d1, d2)
+	}
+}
+
+func TestParseCoordinates(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon string
+		expected location.Coordinates
+	}{
+		{"Decimal", "50.0755", "14.4378", prague},
+		{"Equator And Prime Meridian", "0", "0", location.Coordinates{}},
+		{"Signed", "-33.8688", "+151.2093", location.Coordinates{Lat: -33.8688, Lon: 151.2093}},
+		{"Hemisphere Suffix", "33.8688S", "151.2093 E", location.Coordinates{Lat: -33.8688, Lon: 151.2093}},
+		{"Hemisphere Prefix", "n50.0755", "W 0.1278", location.Coordinates{Lat: 50.0755, Lon: -0.1278}},
+		{"DMS", `50°4'31.8"N`, `14°26′16.08″E`, prague},
+		{"DMS Spaces", "50 4 31.8 N", "14 26 16.08", prague},
+		{"Degrees Decimal Minutes", "50:04.53", "14°26.268'", prague},
+		{"Antimeridian Kept", "0", "180", location.Coordinates{Lon: 180}},
+		{"Longitude Wraps", "0", "190", location.Coordinates{Lon: -170}},
+		{"Longitude Wraps West", "0", "-540.5", location.Coordinates{Lon: 179.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, err := ParseLatitude(tt.lat)
+			if err != nil {
+				t.Fatalf("ParseLatitude(%q) error = %v", tt.lat, err)
+			}
+			lon, err := ParseLongitude(tt.lon)
+			if err != nil {
+				t.Fatalf("ParseLongitude(%q) error = %v", tt.lon, err)
+			}
+			if !almostEqual(lat, tt.expected.Lat, 1e-9) || !almostEqual(lon, tt.expected.Lon, 1e-9) {
+				t.Errorf("parsed (%v, %v), want %v", lat, lon, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCoordinatesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(string) (float64, error)
+		input string
+	}{
+		{"Empty", ParseLatitude, " "},
+		{"Not A Number", ParseLatitude, "abc"},
+		{"Latitude Out Of Range", ParseLatitude, "90.5"},
+		{"Latitude DMS Out Of Range", ParseLatitude, "91 0 0 S"},
+		{"Wrong Hemisphere", ParseLatitude, "50E"},
+		{"Two Hemispheres", ParseLongitude, "E14W"},
+		{"Sign And Hemisphere", ParseLongitude, "-14E"},
+		{"Minutes Too Large", ParseLatitude, "50 60 0"},
+		{"Fraction Before Last Part", ParseLatitude, "50.5 30"},
+		{"Too Many Parts", ParseLongitude, "1 2 3 4"},
+		{"Exponent", ParseLongitude, "1e2"},
+		{"NaN", ParseLongitude, "NaN"},
+		{"Infinity", ParseLatitude, "Inf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := tt.parse(tt.input)
+			var coordErr *CoordinateError
+			if !errors.As(err, &coordErr) {
+				t.Errorf("parsing %q = %v, %v, want a *CoordinateError", tt.input, v, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+// CoordinateError tells why a latitude or longitude was rejected.
+type CoordinateError struct {
+	Axis   string `json:"axis"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (e *CoordinateError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Axis, e.Value, e.Reason)
+}
+
+const (
+	axisLat = "latitude"
+	axisLon = "longitude"
+)
+
+// ParseLatitude reads decimal degrees ("-33.8688"), a hemisphere letter before
+// or after the value ("33.8688S", "S 33.8688") and degrees, minutes and seconds
+// ("33°52'7.7\"S", "33 52 7.7 S", "33:52.13S"). Values outside [-90, 90] are rejected.
+func ParseLatitude(s string) (float64, error) {
+	lat, err := parseAngle(s, axisLat, 'N', 'S')
+	if err != nil {
+		return 0, err
+	}
+	if lat < -90 || lat > 90 {
+		return 0, &CoordinateError{Axis: axisLat, Value: s, Reason: "must be within [-90, 90]"}
+	}
+	return lat, nil
+}
+
+// ParseLongitude reads the formats of ParseLatitude with E and W as the
+// hemispheres. Values outside [-180, 180] are wrapped around the antimeridian.
+func ParseLongitude(s string) (float64, error) {
+	lon, err := parseAngle(s, axisLon, 'E', 'W')
+	if err != nil {
+		return 0, err
+	}
+	return wrapLon(lon), nil
+}
+
+// NormalizeCoordinates checks coordinates given as numbers, the longitude is
+// wrapped as in ParseLongitude.
+func NormalizeCoordinates(c location.Coordinates) (location.Coordinates, error) {
+	if math.IsNaN(c.Lat) || c.Lat < -90 || c.Lat > 90 {
+		return c, &CoordinateError{Axis: axisLat, Value: strconv.FormatFloat(c.Lat, 'f', -1, 64), Reason: "must be within [-90, 90]"}
+	}
+	if math.IsNaN(c.Lon) || math.IsInf(c.Lon, 0) {
+		return c, &CoordinateError{Axis: axisLon, Value: strconv.FormatFloat(c.Lon, 'f', -1, 64), Reason: "is not a number"}
+	}
+	c.Lon = wrapLon(c.Lon)
+	return c, nil
+}
+
+// wrapLon leaves [-180, 180] alone, so 180 does not turn into -180.
+func wrapLon(lon float64) float64 {
+	if lon >= -180 && lon <= 180 {
+		return lon
+	}
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+func isAngleSeparator(r rune) bool {
+	switch r {
+	case '°', 'º', '\'', '′', '’', '"', '″', '”', ':':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+func parseAngle(s, axis string, positive, negative byte) (float64, error) {
+	fail := func(reason string) (float64, error) {
+		return 0, &CoordinateError{Axis: axis, Value: s, Reason: reason}
+	}
+
+	v := strings.TrimSpace(s)
+	if v == "" {
+		return fail("is empty")
+	}
+
+	sign := 1.0
+	hemisphere := false
+	takeHemisphere := func(c byte) (bool, error) {
+		c |= 0x20 // ascii lowercase
+		if c < 'a' || c > 'z' {
+			return false, nil
+		}
+		if c != positive|0x20 && c != negative|0x20 {
+			return false, fmt.Errorf("hemisphere must be %c or %c", positive, negative)
+		}
+		if hemisphere {
+			return false, fmt.Errorf("has two hemisphere letters")
+		}
+		hemisphere = true
+		if c == negative|0x20 {
+			sign = -1
+		}
+		return true, nil
+	}
+
+	if ok, err := takeHemisphere(v[0]); err != nil {
+		return fail(err.Error())
+	} else if ok {
+		v = strings.TrimSpace(v[1:])
+	}
+	if v != "" {
+		if ok, err := takeHemisphere(v[len(v)-1]); err != nil {
+			return fail(err.Error())
+		} else if ok {
+			v = strings.TrimSpace(v[:len(v)-1])
+		}
+	}
+
+	if v != "" && (v[0] == '-' || v[0] == '+') {
+		if hemisphere {
+			return fail("cannot have both a sign and a hemisphere")
+		}
+		if v[0] == '-' {
+			sign = -1
+		}
+		v = v[1:]
+	}
+
+	parts := strings.FieldsFunc(v, isAngleSeparator)
+	if len(parts) == 0 || len(parts) > 3 {
+		return fail("expected decimal degrees or degrees, minutes and seconds")
+	}
+
+	var value float64
+	for i, part := range parts {
+		if strings.Trim(part, "0123456789.") != "" || strings.Count(part, ".") > 1 || strings.Trim(part, ".") == "" {
+			return fail(fmt.Sprintf("%q is not a number", part))
+		}
+		if i < len(parts)-1 && strings.Contains(part, ".") {
+			return fail("only the last of degrees, minutes and seconds may have a fraction")
+		}
+
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return fail(fmt.Sprintf("%q is not a number", part))
+		}
+		if i > 0 && n >= 60 {
+			return fail("minutes and seconds must be below 60")
+		}
+		value += n / math.Pow(60, float64(i))
+	}
+
+	return sign * value, nil
+}
@@ -0,0 +1,114 @@
+// Package geohash names cells of a latitude/longitude grid. Every extra
+// character splits a cell into 32, precision 5 is about 4.9 x 4.9 km at the
+// equator, 6 about 1.2 x 0.6 km and 7 about 153 x 153 m.
+package geohash
+
+import "fmt"
+
+const (
+	MinPrecision = 1
+	MaxPrecision = 12
+
+	alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var decodeMap = func() (m [256]int8) {
+	for i := range m {
+		m[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		m[alphabet[i]] = int8(i)
+	}
+	return m
+}()
+
+// Encode returns the cell holding the point, precision is clamped to
+// [MinPrecision, MaxPrecision].
+func Encode(lat, lon float64, precision int) string {
+	precision = min(max(precision, MinPrecision), MaxPrecision)
+
+	var buf [MaxPrecision]byte
+	latLo, latHi := -90.0, 90.0
+	lonLo, lonHi := -180.0, 180.0
+	even := true
+
+	for i := 0; i < precision; i++ {
+		idx := 0
+		for bit := 4; bit >= 0; bit-- {
+			if even {
+				mid := (lonLo + lonHi) / 2
+				if lon >= mid {
+					idx |= 1 << bit
+					lonLo = mid
+				} else {
+					lonHi = mid
+				}
+			} else {
+				mid := (latLo + latHi) / 2
+				if lat >= mid {
+					idx |= 1 << bit
+					latLo = mid
+				} else {
+					latHi = mid
+				}
+			}
+			even = !even
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf[:precision])
+}
+
+// Bounds returns the south-west and north-east corners of a cell.
+func Bounds(hash string) (minLat, minLon, maxLat, maxLon float64, err error) {
+	if len(hash) < MinPrecision || len(hash) > MaxPrecision {
+		return 0, 0, 0, 0, fmt.Errorf("geohash %q must have %d to %d characters", hash, MinPrecision, MaxPrecision)
+	}
+
+	minLat, maxLat = -90, 90
+	minLon, maxLon = -180, 180
+	even := true
+
+	for i := 0; i < len(hash); i++ {
+		idx := decodeMap[hash[i]]
+		if idx < 0 {
+			return 0, 0, 0, 0, fmt.Errorf("geohash %q has an invalid character %q", hash, hash[i])
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := idx&(1<<bit) != 0
+			if even {
+				mid := (minLon + maxLon) / 2
+				if set {
+					minLon = mid
+				} else {
+					maxLon = mid
+				}
+			} else {
+				mid := (minLat + maxLat) / 2
+				if set {
+					minLat = mid
+				} else {
+					maxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return minLat, minLon, maxLat, maxLon, nil
+}
+
+// Center returns the middle of a cell.
+func Center(hash string) (lat, lon float64, err error) {
+	minLat, minLon, maxLat, maxLon, err := Bounds(hash)
+	if err != nil {
+		return 0, 0, err
+	}
+	return (minLat + maxLat) / 2, (minLon + maxLon) / 2, nil
+}
+
+// Snap moves a point to the center of its cell, so every point of a cell
+// gives the same answer.
+func Snap(lat, lon float64, precision int) (float64, float64) {
+	lat, lon, _ = Center(Encode(lat, lon, precision))
+	return lat, lon
+}
@@ -0,0 +1,65 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name      string
+		lat, lon  float64
+		precision int
+		expected  string
+	}{
+		{"Prague", 50.0755, 14.4378, 7, "u2fkbec"},
+		{"Jutland", 57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{"Origin", 0, 0, 5, "s0000"},
+		{"South West Corner", -90, -180, 3, "000"},
+		{"North East Corner", 90, 180, 3, "zzz"},
+		{"Clamped Precision", 50.0755, 14.4378, 0, "u"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Encode(tt.lat, tt.lon, tt.precision); got != tt.expected {
+				t.Errorf("Encode(%v, %v, %d) = %q, want %q", tt.lat, tt.lon, tt.precision, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	for _, hash := range []string{"u2fkbec", "u4pruydqqvj", "s0000", "7zzzz"} {
+		minLat, minLon, maxLat, maxLon, err := Bounds(hash)
+		if err != nil {
+			t.Fatalf("Bounds(%q) error = %v", hash, err)
+		}
+		lat, lon, _ := Center(hash)
+		if lat < minLat || lat > maxLat || lon < minLon || lon > maxLon {
+			t.Errorf("Center(%q) = %v, %v lies outside its cell", hash, lat, lon)
+		}
+		if got := Encode(lat, lon, len(hash)); got != hash {
+			t.Errorf("Encode(Center(%q)) = %q", hash, got)
+		}
+	}
+
+	for _, hash := range []string{"", "u4pruydqqvjx0", "abc"} {
+		if _, _, _, _, err := Bounds(hash); err == nil {
+			t.Errorf("Bounds(%q) accepted an invalid geohash", hash)
+		}
+	}
+}
+
+func TestSnap(t *testing.T) {
+	minLat, _, _, maxLon, _ := Bounds(Encode(50.0755, 14.4378, 6))
+
+	aLat, aLon := Snap(50.0755, 14.4378, 6)
+	bLat, bLon := Snap(minLat+1e-9, maxLon-1e-9, 6)
+	if aLat != bLat || aLon != bLon {
+		t.Errorf("Snap() put points of one cell apart: %v,%v and %v,%v", aLat, aLon, bLat, bLon)
+	}
+	if math.Abs(aLat-50.0755) > 0.003 || math.Abs(aLon-14.4378) > 0.006 {
+		t.Errorf("Snap() = %v, %v moved the point further than its cell", aLat, aLon)
+	}
+}
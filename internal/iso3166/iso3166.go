@@ -0,0 +1,197 @@
+// Package iso3166 holds the ISO 3166-1 country and ISO 3166-2 subdivision
+// tables, taken from the Debian iso-codes project and embedded at build time.
+package iso3166
+
+import (
+	_ "embed"
+	"strings"
+
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+//go:embed countries.tsv
+var countriesTsv string
+
+//go:embed aliases.tsv
+var aliasesTsv string
+
+//go:embed localized.tsv
+var localizedTsv string
+
+//go:embed subdivisions.tsv
+var subdivisionsTsv string
+
+//go:embed subdivision_names.tsv
+var subdivisionNamesTsv string
+
+type Country struct {
+	Alpha2  string `json:"alpha2"`
+	Alpha3  string `json:"alpha3"`
+	Numeric string `json:"numeric"`
+	Name    string `json:"name"`
+
+	localNames map[string]string // language -> first translated name
+}
+
+// LocalName is the name in the given language, falling back to English.
+func (c *Country) LocalName(lang string) string {
+	if name, ok := c.localNames[strings.ToLower(lang)]; ok {
+		return name
+	}
+	return c.Name
+}
+
+type Subdivision struct {
+	Code    string `json:"code"`
+	Country string `json:"country"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Parent  string `json:"parent,omitempty"`
+}
+
+var (
+	countries      []Country
+	countryIndex   = make(map[string]*Country)
+	subdivisions   []Subdivision                   // sorted by code, so each country is one run
+	subdivisionIdx = make(map[string]*Subdivision) // "CC:" + normalized code or name
+	countrySubs    = make(map[string][]Subdivision)
+)
+
+// nameKey is the form names are compared in: lowercase, no accents, dashes for
+// spaces. It pins the default romanization so the index and lookups always agree.
+func nameKey(s string) string {
+	return util.CleanQueryWith(strings.ReplaceAll(s, "-", " "), util.Romanization{})
+}
+
+func eachRow(tsv string, fn func(cols []string)) {
+	for line := range strings.SplitSeq(tsv, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, "\t"))
+	}
+}
+
+func init() {
+	eachRow(countriesTsv, func(cols []string) {
+		countries = append(countries, Country{Alpha2: cols[0], Alpha3: cols[1], Numeric: cols[2], Name: cols[3]})
+	})
+	for i := range countries {
+		c := &countries[i]
+		countryIndex[c.Alpha2] = c
+		countryIndex[c.Alpha3] = c
+		countryIndex[c.Numeric] = c
+		countryIndex[nameKey(c.Name)] = c
+	}
+	// earlier names win, a translation never shadows an English name or alias
+	addName := func(alpha2, name string) {
+		key := nameKey(name)
+		if _, taken := countryIndex[key]; !taken && key != "" {
+			countryIndex[key] = countryIndex[alpha2]
+		}
+	}
+	eachRow(countriesTsv, func(cols []string) {
+		if len(cols) > 4 && cols[4] != "" {
+			addName(cols[0], cols[4])
+		}
+	})
+	eachRow(aliasesTsv, func(cols []string) {
+		addName(cols[0], cols[1])
+	})
+	eachRow(localizedTsv, func(cols []string) {
+		c := countryIndex[cols[0]]
+		if c.localNames == nil {
+			c.localNames = make(map[string]string)
+		}
+		lang := strings.ToLower(strings.ReplaceAll(cols[1], "_", "-"))
+		if _, ok := c.localNames[lang]; !ok {
+			c.localNames[lang] = cols[2]
+		}
+		addName(cols[0], cols[2])
+	})
+
+	eachRow(subdivisionsTsv, func(cols []string) {
+		country, _, _ := strings.Cut(cols[0], "-")
+		subdivisions = append(subdivisions, Subdivision{Code: cols[0], Country: country, Name: cols[1], Type: cols[2], Parent: cols[3]})
+	})
+	addSubdivision := func(key string, s *Subdivision) {
+		if _, taken := subdivisionIdx[key]; !taken {
+			subdivisionIdx[key] = s
+		}
+	}
+	// codes first, then top level names so a district never shadows its region
+	for i := range subdivisions {
+		s := &subdivisions[i]
+		_, local, _ := strings.Cut(s.Code, "-")
+		subdivisionIdx[s.Country+":"+strings.ToLower(local)] = s
+	}
+	for _, topLevel := range [...]bool{true, false} {
+		for i := range subdivisions {
+			if s := &subdivisions[i]; (s.Parent == "") == topLevel {
+				addSubdivision(s.Country+":"+nameKey(s.Name), s)
+			}
+		}
+	}
+	eachRow(subdivisionNamesTsv, func(cols []string) {
+		if s, ok := LookupSubdivision(cols[0][:2], cols[0]); ok {
+			addSubdivision(s.Country+":"+nameKey(cols[1]), s)
+		}
+	})
+
+	start := 0
+	for i := 1; i <= len(subdivisions); i++ {
+		if i == len(subdivisions) || subdivisions[i].Country != subdivisions[start].Country {
+			countrySubs[subdivisions[start].Country] = subdivisions[start:i:i]
+			start = i
+		}
+	}
+}
+
+// LookupCountry finds a country by alpha-2, alpha-3, numeric code, English or
+// translated name, or a common alias such as "UK".
+func LookupCountry(s string) (*Country, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	if c, ok := countryIndex[strings.ToUpper(s)]; ok {
+		return c, true
+	}
+	c, ok := countryIndex[nameKey(s)]
+	return c, ok
+}
+
+// NormalizeCountry returns the alpha-2 code of whatever LookupCountry accepts.
+func NormalizeCountry(s string) (string, bool) {
+	c, ok := LookupCountry(s)
+	if !ok {
+		return "", false
+	}
+	return c.Alpha2, true
+}
+
+// LookupSubdivision finds a subdivision of the alpha-2 country by its code,
+// with or without the "CC-" prefix, or by its name.
+func LookupSubdivision(country, s string) (*Subdivision, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, false
+	}
+	if prefix, local, ok := strings.Cut(s, "-"); ok && strings.EqualFold(prefix, country) {
+		s = local
+	}
+	if sub, ok := subdivisionIdx[country+":"+strings.ToLower(s)]; ok {
+		return sub, true
+	}
+	sub, ok := subdivisionIdx[country+":"+nameKey(s)]
+	return sub, ok
+}
+
+func Countries() []Country {
+	return countries
+}
+
+// Subdivisions lists every subdivision of the alpha-2 country, top level and nested.
+func Subdivisions(country string) []Subdivision {
+	return countrySubs[strings.ToUpper(country)]
+}
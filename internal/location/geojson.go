@@ -0,0 +1,48 @@
+package location
+
+type Geometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type Feature struct {
+	Type       string     `json:"type"`
+	Geometry   Geometry   `json:"geometry"`
+	Properties *GeoResult `json:"properties"`
+}
+
+// FeatureCollection holds Feature values or their pre-encoded json.RawMessage form.
+type FeatureCollection struct {
+	Type     string `json:"type"`
+	Features []any  `json:"features"`
+	*PageInfo
+}
+
+func (g *GeoResult) Feature() Feature {
+	return Feature{
+		Type: "Feature",
+		Geometry: Geometry{
+			Type:        "Point",
+			Coordinates: [2]float64{g.Lon, g.Lat},
+		},
+		Properties: g,
+	}
+}
+
+func NewFeatureCollection(features []any) FeatureCollection {
+	return FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: features,
+	}
+}
+
+func (p *GeoResultPage) FeatureCollection() FeatureCollection {
+	features := make([]any, len(p.Results))
+	for i := range p.Results {
+		features[i] = p.Results[i].Feature()
+	}
+
+	fc := NewFeatureCollection(features)
+	fc.PageInfo = &p.PageInfo
+	return fc
+}
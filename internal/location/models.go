@@ -1,13 +1,66 @@
 package location
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+
+	"github.com/7apri/SimpleGOWebserver/internal/geohash"
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
 )
 
 type GeoResult struct {
+	ID         int64             `json:"id,omitempty"`
 	LocalNames map[string]string `json:"local_names"`
 	FullAddress
+	PlaceDetails
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// Feature classes of PlaceDetails, derived from the GeoNames feature code and population.
+const (
+	FeatureCity    = "city"
+	FeatureTown    = "town"
+	FeatureVillage = "village"
+	FeatureSuburb  = "suburb"
+)
+
+// PlaceDetails are the optional components a provider or import may know about a place.
+type PlaceDetails struct {
+	County       string `json:"county,omitempty"`
+	Population   int64  `json:"population,omitempty"`
+	FeatureClass string `json:"feature_class,omitempty"`
+	Elevation    *int32 `json:"elevation_m,omitempty"`
+	Timezone     string `json:"timezone,omitempty"`
+	Source       string `json:"source,omitempty"`
+}
+
+func (g *GeoResult) WithDistance(km float64) *GeoResult {
+	cp := *g
+	cp.DistanceKm = &km
+	return &cp
+}
+
+func (g *GeoResult) WithoutDistance() *GeoResult {
+	if g.DistanceKm == nil {
+		return g
+	}
+	cp := *g
+	cp.DistanceKm = nil
+	return &cp
+}
+
+type PageInfo struct {
+	Page    int  `json:"page"`
+	Limit   int  `json:"limit"`
+	HasMore bool `json:"has_more"`
+}
+
+type GeoResultPage struct {
+	Results []GeoResult `json:"results"`
+	PageInfo
 }
 
 type IpGeoResult struct {
@@ -15,6 +68,8 @@ type IpGeoResult struct {
 	Country  string `json:"countryCode"`
 	State    string `json:"regionName"`
 	CityName string `json:"city"`
+	Timezone string `json:"timezone"`
+	Source   string `json:"-"`
 	Coordinates
 }
 
@@ -35,26 +90,59 @@ type FullAddress struct {
 }
 
 type LocationReadableAddress struct {
-	CityName string `json:"name"`
-	State    string `json:"state,omitempty"`
-	Country  string `json:"country"`
+	CityName   string `json:"name"`
+	State      string `json:"state,omitempty"`
+	Country    string `json:"country"`
+	PostalCode string `json:"postal_code,omitempty"`
 }
 
-func (l *LocationReadableAddress) Key() string {
-	var b strings.Builder
-	b.Grow(len(l.CityName) + len(l.State) + len(l.Country) + 4)
-	b.WriteString("a:")
+// NewReadableAddress cleans the names and turns any spelling of the country
+// that iso3166 knows into its alpha-2 code. An empty country is kept empty,
+// such an address is matched anywhere in the world.
+func NewReadableAddress(city, state, country string) (LocationReadableAddress, error) {
+	if strings.TrimSpace(country) == "" {
+		return LocationReadableAddress{
+			CityName: util.CleanQuery(city),
+			State:    util.CleanQuery(state),
+		}, nil
+	}
 
-	b.WriteString(l.CityName)
-	b.WriteByte(',')
+	code, ok := iso3166.NormalizeCountry(country)
+	if !ok {
+		return LocationReadableAddress{}, fmt.Errorf("unknown country %q", strings.TrimSpace(country))
+	}
+	return LocationReadableAddress{
+		CityName: util.CleanQuery(city),
+		State:    CanonicalState(code, state),
+		Country:  code,
+	}, nil
+}
 
-	if l.State != "" {
-		b.WriteString(l.State)
-		b.WriteByte(',')
+// CanonicalState maps "CA", "US-CA" and "Kalifornien" to the cleaned ISO 3166-2
+// name of the subdivision, unknown states are only cleaned.
+func CanonicalState(country, state string) string {
+	if sub, ok := iso3166.LookupSubdivision(country, state); ok {
+		return util.CleanQuery(sub.Name)
 	}
+	return util.CleanQuery(state)
+}
 
-	b.WriteString(l.Country)
+// keyCountry keeps "CZ", "CZE" and "Czechia" on one cache key even when the
+// address was built without NewReadableAddress.
+func keyCountry(country string) string {
+	if len(country) == 2 && strings.ToUpper(country) == country {
+		return country
+	}
+	if code, ok := iso3166.NormalizeCountry(country); ok {
+		return code
+	}
+	return strings.ToUpper(country)
+}
 
+func (l *LocationReadableAddress) Key() string {
+	var b strings.Builder
+	b.Grow(len(l.CityName) + len(l.State) + len(l.Country) + 4)
+	l.WriteKey(&b)
 	return b.String()
 }
 func (l *LocationReadableAddress) WriteKey(b *strings.Builder) {
@@ -65,42 +153,72 @@ func (l *LocationReadableAddress) WriteKey(b *strings.Builder) {
 		b.WriteString(l.State)
 		b.WriteByte(',')
 	}
-	b.WriteString(l.Country)
+	b.WriteString(keyCountry(l.Country))
 }
 
-type Coordinates struct {
-	Lat float64 `json:"lat"`
-	Lon float64 `json:"lon"`
+// NormalizePostalCode uppercases the code and drops spaces and dashes, so
+// "160 00" and "16000" or "k1a 0b1" and "K1A0B1" are the same code. US ZIP+4
+// codes are cut to the five digit ZIP.
+func NormalizePostalCode(country, code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+
+	if country == "US" && len(code) == 9 {
+		code = code[:5]
+	}
+	return code
 }
 
-func (c *Coordinates) Key() string {
-	var b strings.Builder
-	b.Grow(20)
+// NewPostalAddress validates a postal code input, the country is normalized as
+// in NewReadableAddress.
+func NewPostalAddress(code, country string) (LocationReadableAddress, error) {
+	cc, ok := iso3166.NormalizeCountry(country)
+	if !ok {
+		return LocationReadableAddress{}, fmt.Errorf("unknown country %q", strings.TrimSpace(country))
+	}
 
-	var buf [32]byte
+	normalized := NormalizePostalCode(cc, code)
+	if len(normalized) < 2 || len(normalized) > 10 || strings.ContainsFunc(normalized, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z')
+	}) {
+		return LocationReadableAddress{}, fmt.Errorf("%q is not a valid postal code", strings.TrimSpace(code))
+	}
 
-	b.WriteString("c:")
+	return LocationReadableAddress{Country: cc, PostalCode: normalized}, nil
+}
 
-	res := strconv.AppendFloat(buf[:0], c.Lat, 'f', 2, 64)
-	b.Write(res)
+// PostalKey is the cache key of a postal code, the code must be normalized.
+func PostalKey(country, code string) string {
+	return "z:" + country + ":" + code
+}
 
-	b.WriteByte(',')
+func IDKey(id int64) string {
+	return "l:" + strconv.FormatInt(id, 10)
+}
 
-	res = strconv.AppendFloat(buf[:0], c.Lon, 'f', 2, 64)
-	b.Write(res)
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
 
-	return b.String()
+// Key names the geohash cell of the given precision holding c, every point of
+// the cell shares it. See CellCenter for the point lookups should use.
+func (c *Coordinates) Key(precision int) string {
+	return "c:" + geohash.Encode(c.Lat, c.Lon, precision)
 }
-func (c *Coordinates) WriteKey(b *strings.Builder) {
-	var buf [32]byte
 
+func (c *Coordinates) WriteKey(b *strings.Builder, precision int) {
 	b.WriteString("c:")
+	b.WriteString(geohash.Encode(c.Lat, c.Lon, precision))
+}
 
-	res := strconv.AppendFloat(buf[:0], c.Lat, 'f', 2, 64)
-	b.Write(res)
-
-	b.WriteByte(',')
-
-	res = strconv.AppendFloat(buf[:0], c.Lon, 'f', 2, 64)
-	b.Write(res)
+// CellCenter is the middle of the cell Key names, answering for it instead of
+// c keeps the cache and the database in step.
+func (c *Coordinates) CellCenter(precision int) Coordinates {
+	lat, lon := geohash.Snap(c.Lat, c.Lon, precision)
+	return Coordinates{Lat: lat, Lon: lon}
 }
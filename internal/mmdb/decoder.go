@@ -0,0 +1,210 @@
+package mmdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errCorrupt = errors.New("mmdb: corrupt data section")
+
+// maxDepth guards against pointer loops in a malformed file.
+const maxDepth = 64
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) decodeCtrl(offset uint) (typ int, size uint, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errCorrupt
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ = int(ctrl >> 5)
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errCorrupt
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	if typ == typePointer {
+		return typ, uint(ctrl), offset, nil
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, errCorrupt
+		}
+		v := uintFromBytes(d.buf[offset : offset+n])
+		offset += n
+
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+	return typ, size, offset, nil
+}
+
+func uintFromBytes(b []byte) uint {
+	var v uint
+	for _, x := range b {
+		v = v<<8 | uint(x)
+	}
+	return v
+}
+
+func (d *decoder) decodePointer(ctrl uint, offset uint) (uint, uint, error) {
+	n := ((ctrl >> 3) & 0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errCorrupt
+	}
+	b := d.buf[offset : offset+n]
+	next := offset + n
+
+	switch n {
+	case 1:
+		return (ctrl&0x7)<<8 | uint(b[0]), next, nil
+	case 2:
+		return ((ctrl&0x7)<<16 | uintFromBytes(b)) + 2048, next, nil
+	case 3:
+		return ((ctrl&0x7)<<24 | uintFromBytes(b)) + 526336, next, nil
+	default:
+		return uintFromBytes(b), next, nil
+	}
+}
+
+// decode reads the value at offset and returns it with the offset right after it.
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errCorrupt
+	}
+
+	typ, size, offset, err := d.decodeCtrl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		target, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		val, _, err := d.decode(target, depth+1)
+		return val, next, err
+	}
+
+	// every entry takes at least a byte, a larger count is a broken file that
+	// must not size the allocations below
+	if offset > uint(len(d.buf)) || size > uint(len(d.buf))-offset && (typ == typeMap || typ == typeArray) {
+		return nil, 0, errCorrupt
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			var key, val any
+			key, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			val, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = val
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for range size {
+			var val any
+			val, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, val)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeEndMarker, typeContainer:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		return uint64(uintFromBytes(b)), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		return int64(int32(uint32(uintFromBytes(b)))), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errCorrupt
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("mmdb: unknown data type %d", typ)
+}
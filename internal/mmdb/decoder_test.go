@@ -0,0 +1,188 @@
+package mmdb
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected any
+		next     uint
+	}{
+		{"Empty String", []byte{0x40}, "", 1},
+		{"String", []byte{0x43, 'f', 'o', 'o'}, "foo", 4},
+		{"Double", []byte{0x68, 0x40, 0x49, 0x0f, 0xdb, 0xf4, 0x87, 0xfc, 0xb9}, 50.1239, 9},
+		{"Float", []byte{0x04, 0x08, 0x3f, 0xc0, 0x00, 0x00}, 1.5, 6},
+		{"Bytes", []byte{0x82, 0x01, 0x02}, []byte{1, 2}, 3},
+		{"Uint16 Zero", []byte{0xa0}, uint64(0), 1},
+		{"Uint16", []byte{0xa2, 0x01, 0xf4}, uint64(500), 3},
+		{"Uint32", []byte{0xc4, 0xff, 0xff, 0xff, 0xff}, uint64(4294967295), 5},
+		{"Uint64", []byte{0x02, 0x02, 0x01, 0x00}, uint64(256), 4},
+		{"Uint128", []byte{0x03, 0x03, 0x01, 0x00, 0x00}, new(big.Int).SetUint64(65536), 5},
+		{"Int32 Negative", []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xfe}, int64(-2), 6},
+		{"Int32 Short", []byte{0x01, 0x01, 0x7f}, int64(127), 3},
+		{"Bool True", []byte{0x01, 0x07}, true, 2},
+		{"Bool False", []byte{0x00, 0x07}, false, 2},
+		{"Array", []byte{0x02, 0x04, 0x41, 'a', 0xa1, 0x05}, []any{"a", uint64(5)}, 6},
+		{"Map", []byte{0xe1, 0x42, 'e', 'n', 0x43, 'b', 'a', 'r'}, map[string]any{"en": "bar"}, 8},
+		{
+			"Nested Map",
+			[]byte{0xe1, 0x41, 'k', 0xe1, 0x41, 'n', 0x01, 0x04, 0xa1, 0x07},
+			map[string]any{"k": map[string]any{"n": []any{uint64(7)}}},
+			10,
+		},
+		{"End Marker", []byte{0x00, 0x06}, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.data}
+			got, next, err := d.decode(0, 0)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("decode() = %#v, want %#v", got, tt.expected)
+			}
+			if next != tt.next {
+				t.Errorf("decode() next = %d, want %d", next, tt.next)
+			}
+		})
+	}
+}
+
+func TestDecodeLongSizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		header []byte
+	}{
+		{"One Size Byte", 29 + 7, []byte{0x5d, 7}},
+		{"Two Size Bytes", 285 + 0x0102, []byte{0x5e, 0x01, 0x02}},
+		{"Three Size Bytes", 65821 + 0x010203, []byte{0x5f, 0x01, 0x02, 0x03}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := strings.Repeat("x", tt.length)
+			d := decoder{buf: append(tt.header, s...)}
+
+			got, next, err := d.decode(0, 0)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if got != s {
+				t.Errorf("decode() returned %d bytes, want %d", len(got.(string)), tt.length)
+			}
+			if want := uint(len(tt.header) + tt.length); next != want {
+				t.Errorf("decode() next = %d, want %d", next, want)
+			}
+		})
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	tests := []struct {
+		name    string
+		pointer []byte
+		target  uint
+	}{
+		{"One Byte", []byte{0x21, 0x02}, 0x0102},
+		{"Two Bytes", []byte{0x28, 0x00, 0x10}, 2048 + 0x0010},
+		{"Two Bytes High Bits", []byte{0x2b, 0x00, 0x00}, 2048 + 0x030000},
+		{"Three Bytes", []byte{0x30, 0x00, 0x00, 0x20}, 526336 + 0x20},
+		{"Four Bytes", []byte{0x38, 0x00, 0x00, 0x00, 0x40}, 0x40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.target+4)
+			copy(buf, tt.pointer)
+			copy(buf[tt.target:], []byte{0x43, 'h', 'i', '!'})
+			d := decoder{buf: buf}
+
+			got, next, err := d.decode(0, 0)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if got != "hi!" {
+				t.Errorf("decode() = %#v, want %q", got, "hi!")
+			}
+			// the value after a pointer follows the pointer, not its target
+			if next != uint(len(tt.pointer)) {
+				t.Errorf("decode() next = %d, want %d", next, len(tt.pointer))
+			}
+		})
+	}
+}
+
+func TestDecodeMapWithPointers(t *testing.T) {
+	// {"a": "xy", "b": "xy"} with the key "a" and the second value shared through pointers
+	buf := []byte{
+		0x42, 'x', 'y', // 0: "xy"
+		0x41, 'a', // 3: "a"
+		0xe2,       // 5: map of 2
+		0x20, 0x03, // 6: -> "a"
+		0x20, 0x00, // 8: -> "xy"
+		0x41, 'b', // 10: "b"
+		0x20, 0x00, // 12: -> "xy"
+	}
+	d := decoder{buf: buf}
+
+	got, next, err := d.decode(5, 0)
+	if err != nil {
+		t.Fatalf("decode() error = %v", err)
+	}
+	if want := map[string]any{"a": "xy", "b": "xy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decode() = %#v, want %#v", got, want)
+	}
+	if next != uint(len(buf)) {
+		t.Errorf("decode() next = %d, want %d", next, len(buf))
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", nil},
+		{"Missing Extended Type", []byte{0x01}},
+		{"Short String", []byte{0x45, 'a', 'b'}},
+		{"Missing Size Byte", []byte{0x5d}},
+		{"Short Pointer", []byte{0x28, 0x00}},
+		{"Pointer Past End", []byte{0x27, 0xff}},
+		{"Pointer Loop", []byte{0x20, 0x00}},
+		{"Double Of Wrong Size", []byte{0x64, 0, 0, 0, 0}},
+		{"Float Of Wrong Size", []byte{0x08, 0x08, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"Long Int32", []byte{0x05, 0x01, 0, 0, 0, 0, 1}},
+		{"Non String Key", []byte{0xe1, 0xa1, 0x01, 0x40}},
+		{"Truncated Map", []byte{0xe2, 0x41, 'a', 0x40}},
+		{"Truncated Array", []byte{0x03, 0x04, 0x40}},
+		{"Map Larger Than The File", []byte{0xff, 0xff, 0xff, 0xff, 0x40}},
+		{"Array Larger Than The File", []byte{0x1f, 0x04, 0xff, 0xff, 0xff, 0x40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := decoder{buf: tt.data}
+			if got, _, err := d.decode(0, 0); err == nil {
+				t.Errorf("decode() = %#v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestDecodeUnknownType(t *testing.T) {
+	d := decoder{buf: []byte{0x00, 0x0a}}
+	_, _, err := d.decode(0, 0)
+	if err == nil || errors.Is(err, errCorrupt) {
+		t.Errorf("decode() error = %v, want an unknown type error", err)
+	}
+}
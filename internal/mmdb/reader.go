@@ -0,0 +1,134 @@
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+)
+
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+var ErrNotFound = errors.New("mmdb: address not found")
+
+type Metadata struct {
+	DatabaseType string
+	IPVersion    uint
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+// Reader looks addresses up in a MaxMind DB file held fully in memory.
+type Reader struct {
+	Metadata
+	tree      []byte
+	data      decoder
+	ipv4Start uint
+}
+
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+func FromBytes(buf []byte) (*Reader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx == -1 {
+		return nil, errors.New("mmdb: metadata marker not found")
+	}
+
+	meta := decoder{buf: buf[idx+len(metadataMarker):]}
+	raw, _, err := meta.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := raw.(map[string]any)
+	if !ok {
+		return nil, errors.New("mmdb: metadata is not a map")
+	}
+
+	r := &Reader{}
+	r.DatabaseType, _ = fields["database_type"].(string)
+	r.BuildEpoch, _ = fields["build_epoch"].(uint64)
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	r.NodeCount, r.RecordSize, r.IPVersion = uint(nodeCount), uint(recordSize), uint(ipVersion)
+
+	if r.RecordSize != 24 && r.RecordSize != 28 && r.RecordSize != 32 {
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.RecordSize)
+	}
+
+	treeSize := r.NodeCount * r.RecordSize / 4
+	dataStart := treeSize + 16
+	if dataStart > uint(idx) {
+		return nil, errors.New("mmdb: search tree exceeds file size")
+	}
+	r.tree = buf[:treeSize]
+	r.data = decoder{buf: buf[dataStart:idx]}
+
+	if r.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.NodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+func (r *Reader) readRecord(node uint, bit uint) uint {
+	switch r.RecordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uintFromBytes(r.tree[off : off+3])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(r.tree[off+3]&0xF0)<<20 | uintFromBytes(r.tree[off:off+3])
+		}
+		return uint(r.tree[off+3]&0x0F)<<24 | uintFromBytes(r.tree[off+4:off+7])
+	default:
+		off := node*8 + bit*4
+		return uintFromBytes(r.tree[off : off+4])
+	}
+}
+
+// Lookup returns the decoded record for addr: maps, slices, strings, float64,
+// uint64, int64, bool, []byte or *big.Int.
+func (r *Reader) Lookup(addr netip.Addr) (any, error) {
+	addr = addr.Unmap()
+
+	node := uint(0)
+	bits := addr.AsSlice()
+
+	if addr.Is4() {
+		if r.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.IPVersion == 4 {
+		return nil, ErrNotFound
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.NodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	if node <= r.NodeCount {
+		return nil, ErrNotFound
+	}
+	if node < r.NodeCount+16 {
+		return nil, errCorrupt
+	}
+
+	offset := node - r.NodeCount - 16
+	val, _, err := r.data.decode(offset, 0)
+	return val, err
+}
@@ -0,0 +1,124 @@
+package mmdb
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"reflect"
+	"testing"
+)
+
+//go:generate go run testdata/generate.go
+
+func TestOpen(t *testing.T) {
+	r, err := Open("testdata/test-ipv6-24.mmdb")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	want := Metadata{DatabaseType: "Test-City", IPVersion: 6, NodeCount: r.NodeCount, RecordSize: 24, BuildEpoch: 1700000000}
+	if r.Metadata != want || r.NodeCount == 0 {
+		t.Errorf("Open() metadata = %+v, want %+v", r.Metadata, want)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	prague := map[string]any{
+		"city":         map[string]any{"names": map[string]any{"en": "Prague", "cs": "Praha"}},
+		"country":      map[string]any{"iso_code": "CZ"},
+		"subdivisions": []any{map[string]any{"names": map[string]any{"en": "Hlavní město Praha"}}},
+		"location":     map[string]any{"latitude": 50.0755, "longitude": 14.4378, "time_zone": "Europe/Prague"},
+	}
+	london := map[string]any{
+		"city":     map[string]any{"names": map[string]any{"en": "London"}},
+		"country":  map[string]any{"iso_code": "GB"},
+		"location": map[string]any{"latitude": 51.5072, "longitude": -0.1276, "time_zone": "Europe/London"},
+	}
+	documentation := map[string]any{
+		"country":  map[string]any{"iso_code": "ZZ"},
+		"location": map[string]any{"latitude": -33.8688, "longitude": 151.2093},
+	}
+
+	tests := []struct {
+		name     string
+		addr     string
+		expected any
+	}{
+		{"Network Start", "1.2.3.0", prague},
+		{"Network End", "1.2.3.255", prague},
+		{"IPv4 Mapped", "::ffff:1.2.3.4", prague},
+		{"Narrow Network", "81.2.69.160", london},
+		{"Outside Narrow Network", "81.2.69.127", nil},
+		{"Neighbouring Network", "1.2.4.0", nil},
+		{"IPv6", "2001:db8:1::1", documentation},
+		{"Unknown IPv6", "2001:db9::1", nil},
+	}
+
+	for _, file := range []string{"test-ipv6-24.mmdb", "test-ipv6-28.mmdb", "test-ipv6-32.mmdb", "test-ipv4-24.mmdb"} {
+		r, err := Open("testdata/" + file)
+		if err != nil {
+			t.Fatalf("Open(%q) error = %v", file, err)
+		}
+
+		for _, tt := range tests {
+			t.Run(file+"/"+tt.name, func(t *testing.T) {
+				addr := netip.MustParseAddr(tt.addr)
+				expected := tt.expected
+				if r.IPVersion == 4 && addr.Unmap().Is6() {
+					expected = nil
+				}
+
+				got, err := r.Lookup(addr)
+				if expected == nil {
+					if !errors.Is(err, ErrNotFound) {
+						t.Errorf("Lookup(%s) = %v, %v, want %v", addr, got, err, ErrNotFound)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Lookup(%s) error = %v", addr, err)
+				}
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Lookup(%s) = %#v, want %#v", addr, got, expected)
+				}
+			})
+		}
+	}
+}
+
+func TestFromBytesRejectsBrokenFiles(t *testing.T) {
+	valid, err := os.ReadFile("testdata/test-ipv4-24.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", nil},
+		{"No Metadata", valid[:len(valid)/2]},
+		{"Truncated Metadata", valid[:len(valid)-3]},
+		{"Tree Past The Data", valid[len(valid)/2:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromBytes(tt.data); err == nil {
+				t.Error("FromBytes() accepted a broken file")
+			}
+		})
+	}
+}
+
+func TestLookupCorruptRecord(t *testing.T) {
+	r, err := Open("testdata/test-broken-map-size.mmdb")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	got, err := r.Lookup(netip.MustParseAddr("1.2.3.4"))
+	if !errors.Is(err, errCorrupt) {
+		t.Errorf("Lookup() = %v, %v, want %v", got, err, errCorrupt)
+	}
+}
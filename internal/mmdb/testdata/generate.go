@@ -0,0 +1,247 @@
+//go:build ignore
+
+// generate writes the MaxMind DB fixtures of reader_test.go, it shares no code
+// with the reader so both cannot agree on the same mistake.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type record = map[string]any
+
+var prague = record{
+	"city":         record{"names": record{"en": "Prague", "cs": "Praha"}},
+	"country":      record{"iso_code": "CZ"},
+	"subdivisions": []any{record{"names": record{"en": "Hlavní město Praha"}}},
+	"location":     record{"latitude": 50.0755, "longitude": 14.4378, "time_zone": "Europe/Prague"},
+}
+
+var london = record{
+	"city":     record{"names": record{"en": "London"}},
+	"country":  record{"iso_code": "GB"},
+	"location": record{"latitude": 51.5072, "longitude": -0.1276, "time_zone": "Europe/London"},
+}
+
+var documentation = record{
+	"country":  record{"iso_code": "ZZ"},
+	"location": record{"latitude": -33.8688, "longitude": 151.2093},
+}
+
+// raw is written to the data section as it is
+type raw []byte
+
+type network struct {
+	prefix netip.Prefix
+	data   any
+}
+
+func main() {
+	v4 := []network{
+		{netip.MustParsePrefix("1.2.3.0/24"), prague},
+		{netip.MustParsePrefix("81.2.69.128/26"), london},
+	}
+	v6 := append(v4, network{netip.MustParsePrefix("2001:db8::/32"), documentation})
+
+	for _, size := range []int{24, 28, 32} {
+		write(fmt.Sprintf("test-ipv6-%d.mmdb", size), 6, size, v6)
+	}
+	write("test-ipv4-24.mmdb", 4, 24, v4)
+
+	// a map claiming 16 million entries with none following
+	write("test-broken-map-size.mmdb", 4, 24, []network{
+		{netip.MustParsePrefix("1.2.3.0/24"), raw{typeMap<<5 | 31, 0xff, 0xff, 0xff}},
+	})
+}
+
+// node records point at another node, at data (-1 - index) or nowhere (0 on a non root node)
+type node [2]int
+
+func write(path string, ipVersion, recordSize int, networks []network) {
+	nodes := []node{{}}
+	for i, n := range networks {
+		addr := n.prefix.Addr()
+		bits := n.prefix.Bits()
+		if ipVersion == 6 && addr.Is4() {
+			// ::a.b.c.d, where the reader looks IPv4 up, not the ::ffff: mapping
+			addr = netip.AddrFrom16(v4InV6(addr))
+			bits += 96
+		}
+		raw := addr.AsSlice()
+
+		cur := 0
+		for b := 0; b < bits; b++ {
+			bit := int(raw[b/8]>>(7-b%8)) & 1
+			if b == bits-1 {
+				nodes[cur][bit] = -1 - i
+				break
+			}
+			if nodes[cur][bit] == 0 {
+				nodes = append(nodes, node{})
+				nodes[cur][bit] = len(nodes) - 1
+			}
+			cur = nodes[cur][bit]
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(networks))
+	for i, n := range networks {
+		offsets[i] = data.Len()
+		encode(&data, n.data)
+	}
+
+	nodeCount := len(nodes)
+	resolve := func(r int) uint32 {
+		switch {
+		case r < 0:
+			return uint32(nodeCount + 16 + offsets[-1-r])
+		case r == 0:
+			return uint32(nodeCount) // no data
+		default:
+			return uint32(r)
+		}
+	}
+
+	var tree bytes.Buffer
+	for _, n := range nodes {
+		left, right := resolve(n[0]), resolve(n[1])
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			tree.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left)})
+			tree.WriteByte(byte(left>>24)<<4 | byte(right>>24)&0x0f)
+			tree.Write([]byte{byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&tree, binary.BigEndian, left)
+			binary.Write(&tree, binary.BigEndian, right)
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encode(&out, record{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test-City",
+		"description":                 record{"en": "SimpleGOWebserver test fixture"},
+		"ip_version":                  uint16(ipVersion),
+		"languages":                   []any{"en", "cs"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+
+	// go generate runs in the package directory
+	if err := os.WriteFile(filepath.Join("testdata", path), out.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func v4InV6(addr netip.Addr) [16]byte {
+	var b [16]byte
+	v4 := addr.As4()
+	copy(b[12:], v4[:])
+	return b
+}
+
+const (
+	typeString  = 2
+	typeDouble  = 3
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeUint64  = 9
+	typeArray   = 11
+	typeBoolean = 14
+)
+
+func header(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl = byte(size)
+	case size < 29+256:
+		ctrl, extra = 29, []byte{byte(size - 29)}
+	case size < 285+65536:
+		s := size - 285
+		ctrl, extra = 30, []byte{byte(s >> 8), byte(s)}
+	default:
+		s := size - 65821
+		ctrl, extra = 31, []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+
+	if typ <= 7 {
+		buf.WriteByte(byte(typ)<<5 | ctrl)
+	} else {
+		buf.WriteByte(ctrl)
+		buf.WriteByte(byte(typ - 7))
+	}
+	buf.Write(extra)
+}
+
+func unsigned(buf *bytes.Buffer, typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	trimmed := bytes.TrimLeft(b[:], "\x00")
+	header(buf, typ, len(trimmed))
+	buf.Write(trimmed)
+}
+
+func encode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case raw:
+		buf.Write(v)
+	case string:
+		header(buf, typeString, len(v))
+		buf.WriteString(v)
+	case float64:
+		header(buf, typeDouble, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		unsigned(buf, typeUint16, uint64(v))
+	case uint32:
+		unsigned(buf, typeUint32, uint64(v))
+	case uint64:
+		unsigned(buf, typeUint64, v)
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		header(buf, typeBoolean, size)
+	case []any:
+		header(buf, typeArray, len(v))
+		for _, x := range v {
+			encode(buf, x)
+		}
+	case record:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		header(buf, typeMap, len(v))
+		for _, k := range keys {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	default:
+		log.Fatalf("cannot encode %T", v)
+	}
+}
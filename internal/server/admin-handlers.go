@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/dedupe"
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+// RequireAdmin lets a request through only with "Authorization: Bearer <AdminToken>".
+// Without a configured token the admin API stays closed.
+func (server *Server) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.AdminToken == "" {
+			util.SendErrorJson(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(server.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			util.SendErrorJson(w, "Missing or invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// locationInput is the admin payload, absent fields keep their value on PATCH.
+type locationInput struct {
+	Name       *string           `json:"name"`
+	State      *string           `json:"state"`
+	Country    *string           `json:"country"`
+	Lat        *float64          `json:"lat"`
+	Lon        *float64          `json:"lon"`
+	LocalNames map[string]string `json:"local_names"`
+
+	// the structured components, "" clears a text field
+	County       *string `json:"county"`
+	PostalCode   *string `json:"postal_code"`
+	Population   *int64  `json:"population"`
+	FeatureClass *string `json:"feature_class"`
+	Elevation    *int32  `json:"elevation_m"`
+	Timezone     *string `json:"timezone"`
+}
+
+func (in *locationInput) applyTo(loc *location.GeoResult) error {
+	if in.Name != nil {
+		loc.CityName = *in.Name
+	}
+	if in.State != nil {
+		loc.State = *in.State
+	}
+	if in.Country != nil {
+		code, ok := iso3166.NormalizeCountry(*in.Country)
+		if !ok {
+			return fmt.Errorf("unknown country %q", *in.Country)
+		}
+		loc.Country = code
+	}
+	if in.Lat != nil {
+		loc.Lat = *in.Lat
+	}
+	if in.Lon != nil {
+		loc.Lon = *in.Lon
+	}
+	if in.LocalNames != nil {
+		loc.LocalNames = in.LocalNames
+	}
+	if in.County != nil {
+		loc.County = strings.TrimSpace(*in.County)
+	}
+	if in.PostalCode != nil {
+		loc.PostalCode = strings.TrimSpace(*in.PostalCode)
+	}
+	if in.Population != nil {
+		if *in.Population < 0 {
+			return errors.New("population must not be negative")
+		}
+		loc.Population = *in.Population
+	}
+	if in.FeatureClass != nil {
+		switch *in.FeatureClass {
+		case "", location.FeatureCity, location.FeatureTown, location.FeatureVillage, location.FeatureSuburb:
+			loc.FeatureClass = *in.FeatureClass
+		default:
+			return fmt.Errorf("unknown feature_class %q, expected city, town, village or suburb", *in.FeatureClass)
+		}
+	}
+	if in.Elevation != nil {
+		loc.Elevation = in.Elevation
+	}
+	if in.Timezone != nil {
+		loc.Timezone = strings.TrimSpace(*in.Timezone)
+	}
+
+	if strings.TrimSpace(loc.CityName) == "" || loc.Country == "" {
+		return errors.New("name and country are required")
+	}
+	c, err := geo.NormalizeCoordinates(loc.Coordinates)
+	if err != nil {
+		return err
+	}
+	loc.Coordinates = c
+	return nil
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(dst); err != nil {
+		util.SendErrorJson(w, "Invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func sendAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrLocationNotFound):
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, database.ErrLocationExists):
+		util.SendErrorJson(w, err.Error(), http.StatusConflict)
+	default:
+		util.SendErrorJson(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+func (server *Server) HandleAdminCreateLocation(w http.ResponseWriter, r *http.Request) {
+	var in locationInput
+	if !decodeBody(w, r, &in) {
+		return
+	}
+	if in.Lat == nil || in.Lon == nil {
+		util.SendErrorJson(w, "lat and lon are required", http.StatusBadRequest)
+		return
+	}
+
+	var loc location.GeoResult
+	if err := in.applyTo(&loc); err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := server.LocationService.CreateLocation(r.Context(), &loc)
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+	util.SendJson(w, http.StatusCreated, created)
+}
+
+func (server *Server) HandleAdminUpdateLocation(w http.ResponseWriter, r *http.Request) {
+	id, err := parseLocationID(r.PathValue("id"))
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var in locationInput
+	if !decodeBody(w, r, &in) {
+		return
+	}
+
+	current, err := server.Database.FindLocationByID(r.Context(), id)
+	if err != nil {
+		util.SendErrorJson(w, database.ErrLocationNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err := in.applyTo(current); err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := server.LocationService.UpdateLocation(r.Context(), id, current)
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+	util.SendJson(w, http.StatusOK, updated)
+}
+
+func (server *Server) HandleAdminDeleteLocation(w http.ResponseWriter, r *http.Request) {
+	id, err := parseLocationID(r.PathValue("id"))
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := server.LocationService.DeleteLocation(r.Context(), id); err != nil {
+		sendAdminError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *Server) HandleAdminMergeLocations(w http.ResponseWriter, r *http.Request) {
+	targetID, err := parseLocationID(r.PathValue("id"))
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		SourceIDs []int64 `json:"source_ids"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+
+	slices.Sort(body.SourceIDs)
+	sourceIDs := slices.Compact(body.SourceIDs)
+	if len(sourceIDs) == 0 || sourceIDs[0] < 1 || slices.Contains(sourceIDs, targetID) {
+		util.SendErrorJson(w, "source_ids must list other positive location ids", http.StatusBadRequest)
+		return
+	}
+
+	merged, err := server.LocationService.MergeLocations(r.Context(), targetID, sourceIDs)
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+	util.SendJson(w, http.StatusOK, merged)
+}
+
+func parseDuplicateQuery(r *http.Request) (country string, thresholdKm float64, err error) {
+	query := r.URL.Query()
+	if country, err = parseCountryFilter(query); err != nil {
+		return "", 0, err
+	}
+	thresholdKm = 10
+
+	if t := query.Get("threshold_km"); t != "" {
+		values, err := parseFloatParams(query, "threshold_km")
+		if err != nil || values[0] <= 0 || values[0] > maxAreaRadiusKm {
+			return "", 0, fmt.Errorf("threshold_km must be between 0 and %d", maxAreaRadiusKm)
+		}
+		thresholdKm = values[0]
+	}
+	return country, thresholdKm, nil
+}
+
+func (server *Server) HandleAdminDuplicates(w http.ResponseWriter, r *http.Request) {
+	country, thresholdKm, err := parseDuplicateQuery(r)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clusters, err := dedupe.Find(r.Context(), server.Database, country, thresholdKm)
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+	util.SendJson(w, http.StatusOK, clusters)
+}
+
+func (server *Server) HandleAdminMergeDuplicates(w http.ResponseWriter, r *http.Request) {
+	country, thresholdKm, err := parseDuplicateQuery(r)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	clusters, err := dedupe.Find(ctx, server.Database, country, thresholdKm)
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+
+	removed, err := dedupe.MergeAll(ctx, clusters, func(ctx context.Context, targetID int64, sourceIDs []int64) error {
+		_, err := server.LocationService.MergeLocations(ctx, targetID, sourceIDs)
+		return err
+	})
+	if err != nil {
+		sendAdminError(w, err)
+		return
+	}
+
+	util.SendJson(w, http.StatusOK, struct {
+		Clusters    []dedupe.Cluster `json:"clusters"`
+		RemovedRows int              `json:"removed_rows"`
+	}{
+		Clusters:    clusters,
+		RemovedRows: removed,
+	})
+}
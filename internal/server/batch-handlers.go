@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/7apri/SimpleGOWebserver/internal/services"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+const (
+	maxBatchItems = 1000
+	maxBatchBody  = 1 << 20
+)
+
+type batchLocationInput struct {
+	Type       string   `json:"type"`
+	LocationID int64    `json:"location_id,omitempty"`
+	Lat        *float64 `json:"lat,omitempty"`
+	Lon        *float64 `json:"lon,omitempty"`
+	City       string   `json:"city,omitempty"`
+	State      string   `json:"state,omitempty"`
+	Country    string   `json:"country,omitempty"`
+	Zip        string   `json:"zip,omitempty"`
+	IP         string   `json:"ip,omitempty"`
+}
+
+// indexedLocationResult is one entry of a batch response or one NDJSON line,
+// Index points back at the input it answers.
+type indexedLocationResult struct {
+	Index  int                    `json:"index"`
+	Status string                 `json:"status"`
+	Result any                    `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Source services.ResolveSource `json:"source,omitempty"`
+}
+
+// fill validates one batch item into in, the type decides which fields are read.
+func (server *Server) fill(r *http.Request, item *batchLocationInput, in *services.LocationResolveIn) error {
+	switch item.Type {
+	case "id":
+		if item.LocationID < 1 {
+			return errors.New("id needs a positive location_id")
+		}
+		in.LocationID = item.LocationID
+	case "coordinates":
+		if item.Lat == nil || item.Lon == nil {
+			return errors.New("coordinates need lat and lon")
+		}
+		c, err := geo.NormalizeCoordinates(location.Coordinates{Lat: *item.Lat, Lon: *item.Lon})
+		if err != nil {
+			return err
+		}
+		in.SetCoordinates(c)
+	case "address":
+		if strings.TrimSpace(item.City) == "" || strings.TrimSpace(item.Country) == "" {
+			return errors.New("address needs city and country")
+		}
+		addr, err := location.NewReadableAddress(item.City, item.State, item.Country)
+		if err != nil {
+			return err
+		}
+		in.LocationReadableAddress = addr
+	case "postal_code":
+		if strings.TrimSpace(item.Zip) == "" || strings.TrimSpace(item.Country) == "" {
+			return errors.New("postal_code needs zip and country")
+		}
+		addr, err := location.NewPostalAddress(item.Zip, item.Country)
+		if err != nil {
+			return err
+		}
+		in.LocationReadableAddress = addr
+	case "ip":
+		ips, err := server.parseIPs(r, item.IP)
+		if err != nil {
+			return err
+		}
+		if len(ips) != 1 {
+			return errors.New("ip takes a single address")
+		}
+		in.IP = ips[0]
+	default:
+		return fmt.Errorf("unknown type %q, expected id, coordinates, address, postal_code or ip", item.Type)
+	}
+	return nil
+}
+
+// HandleLocationBatch resolves a JSON array of inputs. The response has one
+// entry per input at the same index, failed items included.
+func (server *Server) HandleLocationBatch(w http.ResponseWriter, r *http.Request) {
+	var items []batchLocationInput
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&items); err != nil {
+		util.SendErrorJson(w, "Body must be a JSON array of location inputs", http.StatusBadRequest)
+		return
+	}
+	if len(items) > maxBatchItems {
+		util.SendErrorJson(w, fmt.Sprintf("A batch takes at most %d items", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+
+	format := requestedFormat(r)
+
+	ipCount := 0
+	for i := range items {
+		if items[i].Type == "ip" {
+			ipCount++
+		}
+	}
+	workerCount := 8 + min(ipCount, maxIPWorkers)
+
+	if wantsNDJSON(r) {
+		server.streamLocationBatch(w, r, items, format, workerCount)
+		return
+	}
+
+	results := make([]indexedLocationResult, len(items))
+	ins := func(yield func(int, *services.LocationResolveIn) bool) {
+		for i := range items {
+			results[i].Index = i
+
+			in := newResolveIn(format)
+			if err := server.fill(r, &items[i], in); err != nil {
+				resolveInPool.Put(in)
+				results[i].Status = "error"
+				results[i].Error = err.Error()
+				continue
+			}
+			if !yield(i, in) {
+				return
+			}
+		}
+	}
+
+	server.resolveEach(r.Context(), ins, workerCount, func(idx int, in *services.LocationResolveIn, res *location.GeoResult, jsonBytes []byte, err error) bool {
+		out := &results[idx]
+		if err != nil {
+			out.Status = "error"
+			out.Error = err.Error()
+		} else {
+			out.Status = "ok"
+			out.Result = encodeResult(format, res, jsonBytes)
+			out.Source = in.Source
+		}
+		return true
+	})
+
+	util.SendJson(w, http.StatusOK, results)
+}
+
+// streamLocationBatch writes each batch result as its own NDJSON line once it
+// is ready. Inputs are built only as workers free up and nothing is kept after
+// its line is written, a failed write ends the batch.
+func (server *Server) streamLocationBatch(w http.ResponseWriter, r *http.Request, items []batchLocationInput, format services.OutputFormat, workerCount int) {
+	stream := newNDJSONStream(w)
+
+	ins := func(yield func(int, *services.LocationResolveIn) bool) {
+		for i := range items {
+			in := newResolveIn(format)
+			if err := server.fill(r, &items[i], in); err != nil {
+				resolveInPool.Put(in)
+				if stream.Write(&indexedLocationResult{Index: i, Status: "error", Error: err.Error()}) != nil {
+					return
+				}
+				continue
+			}
+			if !yield(i, in) {
+				return
+			}
+		}
+	}
+
+	server.resolveEach(r.Context(), ins, workerCount, func(idx int, in *services.LocationResolveIn, res *location.GeoResult, jsonBytes []byte, err error) bool {
+		line := indexedLocationResult{Index: idx, Status: "ok", Source: in.Source}
+		if err != nil {
+			line = indexedLocationResult{Index: idx, Status: "error", Error: err.Error()}
+		} else {
+			line.Result = encodeResult(format, res, jsonBytes)
+		}
+		return stream.Write(&line) == nil
+	})
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// ParsePublicIP accepts only addresses a geolocation provider can know about.
+func ParsePublicIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%q is not a valid ip address", s)
+	}
+	addr = addr.Unmap()
+
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsUnspecified() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return netip.Addr{}, fmt.Errorf("%s is a private, loopback or reserved address and cannot be geolocated", addr)
+	}
+	for _, p := range reservedPrefixes {
+		if p.Contains(addr) {
+			return netip.Addr{}, fmt.Errorf("%s is a private, loopback or reserved address and cannot be geolocated", addr)
+		}
+	}
+	return addr, nil
+}
+
+// ClientIPResolver finds the address of the caller. Forwarding headers are only
+// believed when the hop that set them is a trusted proxy.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+func NewClientIPResolver(trustedCidrs []string) (*ClientIPResolver, error) {
+	c := &ClientIPResolver{}
+	for _, cidr := range trustedCidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		c.trusted = append(c.trusted, p.Masked())
+	}
+	return c, nil
+}
+
+func (c *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range c.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientIPResolver) ClientIP(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, errors.New("could not determine the client address")
+	}
+	remote = remote.Unmap()
+
+	if !c.isTrusted(remote) {
+		return remote, nil
+	}
+
+	hops := forwardedFor(r.Header)
+	nearest := remote
+	// walk from the nearest hop outwards and stop at the first one we do not run
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// for=unknown and obfuscated for=_hidden hide what lies beyond, the
+			// last address we could read is as far as we get
+			return nearest, nil
+		}
+		addr = addr.Unmap()
+		if !c.isTrusted(addr) || i == 0 {
+			return addr, nil
+		}
+		nearest = addr
+	}
+	return remote, nil
+}
+
+// forwardedFor lists the hop addresses from the Forwarded header, falling back
+// to X-Forwarded-For, ordered from the original client to the last proxy.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+
+	for _, line := range h.Values("Forwarded") {
+		for _, element := range strings.Split(line, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				value = strings.Trim(value, `"`)
+				// for="[2001:db8::1]:4711" and for="192.0.2.1:4711"
+				if host, _, err := net.SplitHostPort(value); err == nil {
+					value = host
+				}
+				hops = append(hops, strings.Trim(value, "[]"))
+			}
+		}
+	}
+	if len(hops) > 0 {
+		return hops
+	}
+
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	c, err := NewClientIPResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		header   string
+		value    string
+		expected string
+	}{
+		{"Untrusted Remote", "1.2.3.4:80", "X-Forwarded-For", "5.6.7.8", "1.2.3.4"},
+		{"Trusted Proxy", "10.0.0.1:80", "X-Forwarded-For", "5.6.7.8", "5.6.7.8"},
+		{"Spoofed Hop Beyond The Client", "10.0.0.1:80", "X-Forwarded-For", "9.9.9.9, 5.6.7.8, 10.0.0.2", "5.6.7.8"},
+		{"Forwarded", "10.0.0.1:80", "Forwarded", `for="[2001:4860::1]:4711";proto=https`, "2001:4860::1"},
+		{"Forwarded Unknown", "10.0.0.1:80", "Forwarded", "for=unknown", "10.0.0.1"},
+		{"Forwarded Obfuscated", "10.0.0.1:80", "Forwarded", "for=_hidden, for=10.0.0.2", "10.0.0.2"},
+		{"No Header", "10.0.0.1:80", "", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+
+			got, err := c.ClientIP(r)
+			if err != nil {
+				t.Fatalf("ClientIP() error = %v", err)
+			}
+			if want := netip.MustParseAddr(tt.expected); got != want {
+				t.Errorf("ClientIP() = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+type countryResponse struct {
+	iso3166.Country
+	LocalName string `json:"local_name,omitempty"`
+	Locations int64  `json:"locations"`
+}
+
+// HandleCountries lists the ISO 3166-1 countries with their number of stored
+// locations, ?lang= adds the translated name.
+func (server *Server) HandleCountries(w http.ResponseWriter, r *http.Request) {
+	lang := r.URL.Query().Get("lang")
+
+	counts, err := server.LocationService.CountryCounts(r.Context())
+	if err != nil {
+		util.SendErrorJson(w, "Failed to count locations", http.StatusInternalServerError)
+		return
+	}
+
+	countries := iso3166.Countries()
+	resp := make([]countryResponse, len(countries))
+	for i := range countries {
+		resp[i].Country = countries[i]
+		resp[i].Locations = counts[countries[i].Alpha2]
+		if lang != "" {
+			resp[i].LocalName = countries[i].LocalName(lang)
+		}
+	}
+
+	util.SendJson(w, http.StatusOK, resp)
+}
+
+type subdivisionResponse struct {
+	iso3166.Subdivision
+	Locations int64 `json:"locations"`
+}
+
+func parseCountryPath(r *http.Request) (string, error) {
+	cc := r.PathValue("cc")
+	code, ok := iso3166.NormalizeCountry(cc)
+	if !ok {
+		return "", fmt.Errorf("unknown country %q", cc)
+	}
+	return code, nil
+}
+
+// HandleCountrySubdivisions lists the top level subdivisions of a country, or
+// the children of ?parent=. Locations stored under a nested subdivision count
+// towards the listed one containing it, states outside ISO 3166-2 are listed
+// without a code at the top level.
+func (server *Server) HandleCountrySubdivisions(w http.ResponseWriter, r *http.Request) {
+	country, err := parseCountryPath(r)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	parent := ""
+	if p := r.URL.Query().Get("parent"); p != "" {
+		sub, ok := iso3166.LookupSubdivision(country, p)
+		if !ok {
+			util.SendErrorJson(w, fmt.Sprintf("unknown subdivision %q", p), http.StatusNotFound)
+			return
+		}
+		parent = sub.Code
+	}
+
+	counts, err := server.LocationService.StateCounts(r.Context(), country)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to count locations", http.StatusInternalServerError)
+		return
+	}
+
+	subs := iso3166.Subdivisions(country)
+	byCode := make(map[string]*iso3166.Subdivision, len(subs))
+	for i := range subs {
+		byCode[subs[i].Code] = &subs[i]
+	}
+
+	resp := make([]subdivisionResponse, 0)
+	index := make(map[string]int)
+	for i := range subs {
+		if subs[i].Parent == parent {
+			index[subs[i].Code] = len(resp)
+			resp = append(resp, subdivisionResponse{Subdivision: subs[i]})
+		}
+	}
+
+	counted := make(map[string]bool, len(subs))
+	for i := range subs {
+		state := util.CleanQuery(subs[i].Name)
+		if counted[state] {
+			continue
+		}
+		counted[state] = true
+
+		for sub := &subs[i]; sub != nil; sub = byCode[sub.Parent] {
+			if at, ok := index[sub.Code]; ok {
+				resp[at].Locations += counts[state]
+				break
+			}
+		}
+	}
+
+	if parent == "" {
+		var other []subdivisionResponse
+		for state, n := range counts {
+			if state != "" && !counted[state] {
+				other = append(other, subdivisionResponse{
+					Subdivision: iso3166.Subdivision{Country: country, Name: state},
+					Locations:   n,
+				})
+			}
+		}
+		slices.SortFunc(other, func(a, b subdivisionResponse) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		resp = append(resp, other...)
+	}
+
+	util.SendJson(w, http.StatusOK, resp)
+}
+
+// HandleCountryCities pages through the locations of a country, ?state= narrows
+// them to one subdivision, the most populated come first.
+func (server *Server) HandleCountryCities(w http.ResponseWriter, r *http.Request) {
+	country, err := parseCountryPath(r)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	page, limit, err := parsePagination(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state := query.Get("state")
+	if state == "-" {
+		state = ""
+	}
+
+	result, err := server.LocationService.FindInRegion(r.Context(), country, state, page, limit)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to query locations", http.StatusInternalServerError)
+		return
+	}
+
+	sendPage(w, requestedFormat(r), result)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/7apri/SimpleGOWebserver/internal/freetext"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/7apri/SimpleGOWebserver/internal/services"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+type freeTextResponse struct {
+	Interpretation freetext.Interpretation `json:"interpretation"`
+	Result         any                     `json:"result"`
+}
+
+// interpretationToResolveIn fills in from a parsed ?q= value.
+func interpretationToResolveIn(it *freetext.Interpretation, in *services.LocationResolveIn) error {
+	switch it.Kind {
+	case freetext.KindCoordinates:
+		in.SetCoordinates(location.Coordinates{Lat: *it.Lat, Lon: *it.Lon})
+	case freetext.KindAddress:
+		addr, err := location.NewReadableAddress(it.City, it.State, it.Country)
+		if err != nil {
+			return err
+		}
+		// the postal code is tried first, the city is the fallback
+		if it.PostalCode != "" {
+			if postal, err := location.NewPostalAddress(it.PostalCode, it.Country); err == nil {
+				addr.PostalCode = postal.PostalCode
+			}
+		}
+		in.LocationReadableAddress = addr
+	default:
+		if it.Country == "" {
+			return fmt.Errorf("could not tell the country of postal code %q, add a country name or code", it.PostalCode)
+		}
+		addr, err := location.NewPostalAddress(it.PostalCode, it.Country)
+		if err != nil {
+			return err
+		}
+		in.LocationReadableAddress = addr
+	}
+	return nil
+}
+
+// handleFreeText serves /api/location?q=, answering with the interpretation
+// it picked next to the resolved location.
+func (server *Server) handleFreeText(w http.ResponseWriter, r *http.Request, q string) {
+	it, err := freetext.Parse(q)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := requestedFormat(r)
+	in := newResolveIn(format)
+	defer resolveInPool.Put(in)
+
+	if err := interpretationToResolveIn(&it, in); err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if in.CityName != "" && in.Country == "" {
+		in.Near, err = server.callerPosition(r)
+		if err != nil {
+			sendBadRequest(w, err)
+			return
+		}
+	}
+
+	res, jsonBytes, err := server.LocationService.ResolveLocation(r.Context(), in)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	util.SendJson(w, http.StatusOK, freeTextResponse{
+		Interpretation: it,
+		Result:         encodeResult(format, res, jsonBytes),
+	})
+}
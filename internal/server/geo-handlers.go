@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+	"github.com/7apri/SimpleGOWebserver/internal/services"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
+)
+
+type distanceEndpoint struct {
+	Point    location.Coordinates `json:"point"`
+	Location *location.GeoResult  `json:"location,omitempty"`
+}
+
+type distanceResponse struct {
+	From           distanceEndpoint     `json:"from"`
+	To             distanceEndpoint     `json:"to"`
+	HaversineKm    float64              `json:"haversine_km"`
+	VincentyKm     *float64             `json:"vincenty_km,omitempty"`
+	InitialBearing float64              `json:"initial_bearing"`
+	FinalBearing   float64              `json:"final_bearing"`
+	Midpoint       location.Coordinates `json:"midpoint"`
+}
+
+// parseEndpoint reads one side of a distance query. Each side is given by
+// <prefix>_location_id, <prefix>_lat/<prefix>_lon, <prefix>_city/<prefix>_state/<prefix>_country,
+// <prefix>_zip/<prefix>_country or <prefix>_ip ("me" for the caller).
+func (server *Server) parseEndpoint(r *http.Request, query url.Values, prefix string, in *services.LocationResolveIn) error {
+	latParam, lonParam := query.Get(prefix+"_lat"), query.Get(prefix+"_lon")
+	cityParam, countryParam := query.Get(prefix+"_city"), query.Get(prefix+"_country")
+	zipParam := query.Get(prefix + "_zip")
+	ipParam := query.Get(prefix + "_ip")
+
+	switch {
+	case query.Get(prefix+"_location_id") != "":
+		id, err := parseLocationID(query.Get(prefix + "_location_id"))
+		if err != nil {
+			return err
+		}
+		in.LocationID = id
+	case latParam != "" || lonParam != "":
+		c, err := parseCoordinateParams(prefix+"_lat", latParam, prefix+"_lon", lonParam)
+		if err != nil {
+			return err
+		}
+		in.SetCoordinates(c)
+	case cityParam != "" && countryParam != "":
+		addr, err := location.NewReadableAddress(cityParam, query.Get(prefix+"_state"), countryParam)
+		if err != nil {
+			return fmt.Errorf("%s: %w", prefix, err)
+		}
+		in.LocationReadableAddress = addr
+	case zipParam != "" && countryParam != "":
+		addr, err := location.NewPostalAddress(zipParam, countryParam)
+		if err != nil {
+			return fmt.Errorf("%s: %w", prefix, err)
+		}
+		in.LocationReadableAddress = addr
+	case ipParam != "":
+		ips, err := server.parseIPs(r, ipParam)
+		if err != nil {
+			return err
+		}
+		if len(ips) != 1 {
+			return fmt.Errorf("%s_ip takes a single ip", prefix)
+		}
+		in.IP = ips[0]
+	default:
+		return fmt.Errorf("%s needs a location id, coordinates, a city and country, a zip and country, or an ip", prefix)
+	}
+	return nil
+}
+
+func (server *Server) resolveEndpoint(ctx context.Context, in *services.LocationResolveIn) (distanceEndpoint, error) {
+	hasCoords := in.HasCoordinates
+	point := in.Coordinates
+
+	res, _, err := server.LocationService.ResolveLocation(ctx, in)
+	if err != nil {
+		if hasCoords {
+			// raw coordinates are still measurable without a known place
+			return distanceEndpoint{Point: point}, nil
+		}
+		return distanceEndpoint{}, err
+	}
+
+	if !hasCoords {
+		point = res.Coordinates
+	}
+	return distanceEndpoint{Point: point, Location: res}, nil
+}
+
+func (server *Server) HandleDistance(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	var sides [2]distanceEndpoint
+	for i, prefix := range [2]string{"from", "to"} {
+		in := resolveInPool.Get().(*services.LocationResolveIn)
+		in.Reset()
+
+		if err := server.parseEndpoint(r, query, prefix, in); err != nil {
+			resolveInPool.Put(in)
+			sendBadRequest(w, err)
+			return
+		}
+
+		side, err := server.resolveEndpoint(ctx, in)
+		resolveInPool.Put(in)
+		if err != nil {
+			util.SendErrorJson(w, fmt.Sprintf("Could not resolve %s: %v", prefix, err), http.StatusNotFound)
+			return
+		}
+		sides[i] = side
+	}
+
+	from, to := sides[0].Point, sides[1].Point
+	resp := distanceResponse{
+		From:           sides[0],
+		To:             sides[1],
+		HaversineKm:    geo.Haversine(from, to),
+		InitialBearing: geo.InitialBearing(from, to),
+		FinalBearing:   geo.FinalBearing(from, to),
+		Midpoint:       geo.Midpoint(from, to),
+	}
+	if km, err := geo.Vincenty(from, to); err == nil {
+		resp.VincentyKm = &km
+	}
+
+	util.SendJson(w, http.StatusOK, resp)
+}
@@ -1,17 +1,26 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"iter"
+	"math"
 	"net/http"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/iso3166"
 	"github.com/7apri/SimpleGOWebserver/internal/location"
 	"github.com/7apri/SimpleGOWebserver/internal/services"
+	"github.com/7apri/SimpleGOWebserver/internal/weather"
 	util "github.com/7apri/SimpleGOWebserver/pkg"
 )
 
@@ -20,6 +29,147 @@ type Server struct {
 	WeatherService  *services.WeatherService
 	Database        *database.Database
 	Templates       *template.Template
+	ClientIP        *ClientIPResolver
+	AdminToken      string
+}
+
+// callerIP is the public address of the requester, used for ip=me.
+func (server *Server) callerIP(r *http.Request) (string, error) {
+	resolver := server.ClientIP
+	if resolver == nil {
+		resolver = &ClientIPResolver{}
+	}
+
+	addr, err := resolver.ClientIP(r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := ParsePublicIP(addr.String()); err != nil {
+		return "", fmt.Errorf("your address %s cannot be geolocated, pass coordinates or a city instead", addr)
+	}
+	return addr.String(), nil
+}
+
+// parseIPs validates an ip= list, "me" stands for the caller.
+func (server *Server) parseIPs(r *http.Request, ipParam string) ([]string, error) {
+	parts := strings.Split(ipParam, ",")
+	ips := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		if strings.EqualFold(strings.TrimSpace(part), "me") {
+			ip, err := server.callerIP(r)
+			if err != nil {
+				return nil, err
+			}
+			ips = append(ips, ip)
+			continue
+		}
+
+		addr, err := ParsePublicIP(part)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, addr.String())
+	}
+	return ips, nil
+}
+
+// defaultLocationCookie holds the id of the location a user picked as their
+// own, it stands in for their position when nothing more precise is known.
+// HandleSetDefaultLocation writes it.
+const defaultLocationCookie = "default_location"
+
+const defaultLocationMaxAge = 365 * 24 * 60 * 60
+
+func defaultLocationCookieFor(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     defaultLocationCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+type defaultLocationRequest struct {
+	LocationID int64 `json:"location_id"`
+}
+
+// HandleSetDefaultLocation serves PUT /api/me/default-location, it stores a known
+// location id in the default_location cookie and answers with the location.
+func (server *Server) HandleSetDefaultLocation(w http.ResponseWriter, r *http.Request) {
+	var req defaultLocationRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		util.SendErrorJson(w, "body must be {\"location_id\": <id>}", http.StatusBadRequest)
+		return
+	}
+	if req.LocationID < 1 {
+		util.SendErrorJson(w, "location_id must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	in := newResolveIn(services.FormatJSON)
+	defer resolveInPool.Put(in)
+	in.LocationID = req.LocationID
+
+	res, jsonBytes, err := server.LocationService.ResolveLocation(r.Context(), in)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	http.SetCookie(w, defaultLocationCookieFor(r, strconv.FormatInt(res.ID, 10), defaultLocationMaxAge))
+	util.SendJson(w, http.StatusOK, encodeResult(services.FormatJSON, res, jsonBytes))
+}
+
+// HandleClearDefaultLocation serves DELETE /api/me/default-location.
+func (server *Server) HandleClearDefaultLocation(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, defaultLocationCookieFor(r, "", -1))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseNear(s string) (location.Coordinates, error) {
+	latStr, lonStr, ok := strings.Cut(s, ",")
+	if !ok {
+		return location.Coordinates{}, paramErrors{{Field: "near", Value: s, Reason: "must be lat,lon"}}
+	}
+	return parseCoordinateParams("near", latStr, "near", lonStr)
+}
+
+// callerPosition tells where the requester is, from near=, the saved default
+// location or their ip, in that order. It returns nil when none of them is known.
+func (server *Server) callerPosition(r *http.Request) (*location.Coordinates, error) {
+	if near := r.URL.Query().Get("near"); near != "" {
+		c, err := parseNear(near)
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+
+	in := newResolveIn(services.FormatJSON)
+	defer resolveInPool.Put(in)
+
+	if cookie, err := r.Cookie(defaultLocationCookie); err == nil {
+		if id, err := parseLocationID(cookie.Value); err == nil {
+			in.LocationID = id
+		}
+	}
+	if in.LocationID == 0 {
+		ip, err := server.callerIP(r)
+		if err != nil {
+			return nil, nil
+		}
+		in.IP = ip
+	}
+
+	res, _, err := server.LocationService.ResolveLocation(r.Context(), in)
+	if err != nil {
+		return nil, nil
+	}
+	return &location.Coordinates{Lat: res.Lat, Lon: res.Lon}, nil
 }
 
 func (server *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
@@ -67,104 +217,669 @@ var resolveInPool = sync.Pool{
 	},
 }
 
+func newResolveIn(format services.OutputFormat) *services.LocationResolveIn {
+	in := resolveInPool.Get().(*services.LocationResolveIn)
+	in.Reset()
+	in.Format = format
+	return in
+}
+
+type resolveDone func(idx int, in *services.LocationResolveIn, res *location.GeoResult, jsonBytes []byte, err error) bool
+
+// resolveAll resolves every input on a bounded pool of workers. done runs on the
+// worker goroutines and the inputs go back to the pool once it returns.
+func (server *Server) resolveAll(ctx context.Context, ins []*services.LocationResolveIn, workerCount int, done resolveDone) {
+	server.resolveEach(ctx, slices.All(ins), min(workerCount, len(ins)), done)
+}
+
+type resolveJob struct {
+	idx int
+	in  *services.LocationResolveIn
+}
+
+// resolveEach is resolveAll for inputs built on demand, the pull from ins waits
+// while every worker is busy so only about 2*workerCount inputs exist at a time.
+// Once done returns false the inputs still waiting are dropped unresolved.
+func (server *Server) resolveEach(ctx context.Context, ins iter.Seq2[int, *services.LocationResolveIn], workerCount int, done resolveDone) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	jobs := make(chan resolveJob, workerCount)
+	var wg sync.WaitGroup
+
+	for range workerCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() == nil {
+					res, jsonBytes, err := server.LocationService.ResolveLocation(ctx, job.in)
+					if !done(job.idx, job.in, res, jsonBytes, err) {
+						stop()
+					}
+				}
+				resolveInPool.Put(job.in)
+			}
+		}()
+	}
+
+	for idx, in := range ins {
+		if ctx.Err() != nil {
+			resolveInPool.Put(in)
+			break
+		}
+		jobs <- resolveJob{idx: idx, in: in}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// encodeResult prefers the pre-marshaled bytes of a hot cache entry.
+func encodeResult(format services.OutputFormat, res *location.GeoResult, jsonBytes []byte) any {
+	if jsonBytes != nil {
+		return json.RawMessage(jsonBytes)
+	}
+	if format == services.FormatGeoJSON {
+		return res.Feature()
+	}
+	return res
+}
+
+const geoJsonContentType = "application/geo+json"
+
+func requestedFormat(r *http.Request) services.OutputFormat {
+	if strings.EqualFold(r.URL.Query().Get("format"), "geojson") ||
+		strings.Contains(r.Header.Get("Accept"), geoJsonContentType) {
+		return services.FormatGeoJSON
+	}
+	return services.FormatJSON
+}
+
+func sendPage(w http.ResponseWriter, format services.OutputFormat, page *location.GeoResultPage) {
+	if format == services.FormatGeoJSON {
+		util.SendJsonAs(w, http.StatusOK, geoJsonContentType, page.FeatureCollection())
+		return
+	}
+	util.SendJson(w, http.StatusOK, page)
+}
+
+// queryListsError names the parameters of a *util.ColumnLengthError, other
+// errors are returned as they are.
+func queryListsError(err error, names ...string) error {
+	var lenErr *util.ColumnLengthError
+	if !errors.As(err, &lenErr) {
+		return err
+	}
+
+	parts := make([]string, 0, len(names))
+	for i, n := range lenErr.Lengths {
+		if n > 0 && i < len(names) {
+			parts = append(parts, fmt.Sprintf("%s has %d", names[i], n))
+		}
+	}
+	return fmt.Errorf("%s must list the same number of values (%s)", strings.Join(names, ", "), strings.Join(parts, ", "))
+}
+
 func (server *Server) HandleLocation(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
+	if q := query.Get("q"); q != "" {
+		server.handleFreeText(w, r, q)
+		return
+	}
+
 	ctx := r.Context()
+	format := requestedFormat(r)
 
 	var (
 		coords    []location.Coordinates
 		addresses []location.LocationReadableAddress
+		postals   []location.LocationReadableAddress
 		ips       []string
 	)
 
 	if latParam, lonParam := query.Get("lat"), query.Get("lon"); latParam != "" && lonParam != "" {
-		coords = util.ParseGenericQuery(func(row []string) location.Coordinates {
-			lt, _ := strconv.ParseFloat(row[0], 64)
-			ln, _ := strconv.ParseFloat(row[1], 64)
-			return location.Coordinates{Lat: lt, Lon: ln}
+		var coordErrs paramErrors
+		var err error
+		coords, err = util.ParseGenericQueryStrict(func(row []string) location.Coordinates {
+			c, err := parseCoordinateParams("lat", row[0], "lon", row[1])
+			if err != nil {
+				coordErrs = append(coordErrs, err.(paramErrors)...)
+			}
+			return c
 		}, latParam, lonParam)
+		if err != nil {
+			util.SendErrorJson(w, queryListsError(err, "lat", "lon").Error(), http.StatusBadRequest)
+			return
+		}
+		if coordErrs != nil {
+			sendBadRequest(w, coordErrs)
+			return
+		}
 	}
 
-	if cityParam, countryParam := query.Get("city"), query.Get("country"); cityParam != "" && countryParam != "" {
-		addresses = util.ParseGenericQuery(func(row []string) location.LocationReadableAddress {
+	// without a country every city is matched worldwide
+	if cityParam, countryParam := query.Get("city"), query.Get("country"); cityParam != "" {
+		var addrErr error
+		var err error
+		addresses, err = util.ParseGenericQueryStrict(func(row []string) location.LocationReadableAddress {
 			state := row[1]
 			if state == "-" {
 				state = ""
 			}
-			return location.LocationReadableAddress{
-				CityName: util.CleanQuery(row[0]),
-				State:    util.CleanQuery(state),
-				Country:  strings.ToUpper(strings.TrimSpace(row[2])),
+			addr, err := location.NewReadableAddress(row[0], state, row[2])
+			if err != nil && addrErr == nil {
+				addrErr = err
 			}
+			return addr
 		}, cityParam, query.Get("state"), countryParam)
+		if err != nil {
+			util.SendErrorJson(w, queryListsError(err, "city", "state", "country").Error(), http.StatusBadRequest)
+			return
+		}
+		if addrErr != nil {
+			util.SendErrorJson(w, addrErr.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if zipParam, countryParam := query.Get("zip"), query.Get("country"); zipParam != "" && countryParam != "" {
+		var zipErr error
+		var err error
+		postals, err = util.ParseGenericQueryStrict(func(row []string) location.LocationReadableAddress {
+			addr, err := location.NewPostalAddress(row[0], row[1])
+			if err != nil && zipErr == nil {
+				zipErr = err
+			}
+			return addr
+		}, zipParam, countryParam)
+		if err != nil {
+			util.SendErrorJson(w, queryListsError(err, "zip", "country").Error(), http.StatusBadRequest)
+			return
+		}
+		if zipErr != nil {
+			util.SendErrorJson(w, zipErr.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	if ipParam := query.Get("ip"); ipParam != "" {
-		ips = strings.Split(ipParam, ",")
+		var err error
+		ips, err = server.parseIPs(r, ipParam)
+		if err != nil {
+			util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	totalExpected := len(coords) + len(addresses) + len(ips)
-	finalData := make([]any, totalExpected)
+	var ids []int64
+	if idParam := query.Get("location_id"); idParam != "" {
+		for part := range strings.SplitSeq(idParam, ",") {
+			id, err := parseLocationID(part)
+			if err != nil {
+				util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
 
-	type job struct {
-		index uint
-		in    *services.LocationResolveIn
+	ins := make([]*services.LocationResolveIn, 0, len(coords)+len(addresses)+len(postals)+len(ips)+len(ids))
+	for _, c := range coords {
+		in := newResolveIn(format)
+		in.SetCoordinates(c)
+		ins = append(ins, in)
+	}
+	var near *location.Coordinates
+	if slices.ContainsFunc(addresses, func(a location.LocationReadableAddress) bool { return a.Country == "" }) {
+		var err error
+		near, err = server.callerPosition(r)
+		if err != nil {
+			sendBadRequest(w, err)
+			return
+		}
 	}
 
-	jobs := make(chan job, totalExpected)
-	var wg sync.WaitGroup
+	for _, a := range addresses {
+		in := newResolveIn(format)
+		in.LocationReadableAddress = a
+		if a.Country == "" {
+			in.Near = near
+		}
+		ins = append(ins, in)
+	}
+	for _, p := range postals {
+		in := newResolveIn(format)
+		in.LocationReadableAddress = p
+		ins = append(ins, in)
+	}
+	for _, ip := range ips {
+		in := newResolveIn(format)
+		in.IP = ip
+		ins = append(ins, in)
+	}
+	for _, id := range ids {
+		in := newResolveIn(format)
+		in.LocationID = id
+		ins = append(ins, in)
+	}
 
-	workerCount := min(totalExpected, 8)
+	// ip lookups mostly wait for a shared ip-api batch, so they get extra workers
+	workerCount := 8 + min(len(ips), maxIPWorkers)
 
-	for range workerCount {
-		go func() {
-			for j := range jobs {
-				res, jsonBytes, err := server.LocationService.ResolveLocation(ctx, j.in)
-
-				if err == nil {
-					if jsonBytes != nil {
-						finalData[j.index] = json.RawMessage(jsonBytes)
-					} else {
-						finalData[j.index] = res
-					}
-				}
+	if wantsNDJSON(r) {
+		stream := newNDJSONStream(w)
+		server.resolveAll(ctx, ins, workerCount, func(idx int, in *services.LocationResolveIn, res *location.GeoResult, jsonBytes []byte, err error) bool {
+			line := indexedLocationResult{Index: idx, Status: "ok", Source: in.Source}
+			if err != nil {
+				line = indexedLocationResult{Index: idx, Status: "error", Error: err.Error()}
+			} else {
+				line.Result = encodeResult(format, res, jsonBytes)
+			}
+			// a client that went away gets no more lookups
+			return stream.Write(&line) == nil
+		})
+		return
+	}
+
+	finalData := make([]any, len(ins))
+
+	server.resolveAll(ctx, ins, workerCount, func(idx int, in *services.LocationResolveIn, res *location.GeoResult, jsonBytes []byte, err error) bool {
+		if err == nil {
+			finalData[idx] = encodeResult(format, res, jsonBytes)
+		}
+		return true
+	})
+
+	finalData = slices.DeleteFunc(finalData, func(r any) bool { return r == nil })
+
+	if format == services.FormatGeoJSON {
+		util.SendJsonAs(w, http.StatusOK, geoJsonContentType, location.NewFeatureCollection(finalData))
+		return
+	}
+	util.SendJson(w, http.StatusOK, finalData)
+}
+
+const maxIPWorkers = 100
+
+func parseLocationID(s string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%q is not a valid location id", s)
+	}
+	return id, nil
+}
+
+func (server *Server) HandleLocationByID(w http.ResponseWriter, r *http.Request) {
+	id, err := parseLocationID(r.PathValue("id"))
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-				resolveInPool.Put(j.in)
-				wg.Done()
+	format := requestedFormat(r)
+	in := newResolveIn(format)
+	defer resolveInPool.Put(in)
+	in.LocationID = id
+
+	res, jsonBytes, err := server.LocationService.ResolveLocation(r.Context(), in)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if format == services.FormatGeoJSON {
+		util.SendJsonAs(w, http.StatusOK, geoJsonContentType, encodeResult(format, res, jsonBytes))
+		return
+	}
+	util.SendJson(w, http.StatusOK, encodeResult(format, res, jsonBytes))
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	// maxPage keeps (page-1)*limit, the query offset, far from overflowing
+	maxPage         = 1_000_000
+	maxAreaRadiusKm = 500
+	// maxBoxSpanKm bounds each side of a box like maxAreaRadiusKm bounds a circle
+	maxBoxSpanKm = 2 * maxAreaRadiusKm
+)
+
+func parsePagination(query url.Values) (page, limit int, err error) {
+	page, limit = 1, defaultPageLimit
+
+	if p := query.Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 || page > maxPage {
+			return 0, 0, fmt.Errorf("page must be between 1 and %d", maxPage)
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	return page, limit, nil
+}
+
+func parseFloatParams(query url.Values, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		v, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, paramErrors{{Field: name, Value: query.Get(name), Reason: "must be a number"}}
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// parseCountryFilter reads the optional country= filter as an alpha-2 code.
+func parseCountryFilter(query url.Values) (string, error) {
+	country := query.Get("country")
+	if strings.TrimSpace(country) == "" {
+		return "", nil
+	}
+	code, ok := iso3166.NormalizeCountry(country)
+	if !ok {
+		return "", fmt.Errorf("unknown country %q", country)
+	}
+	return code, nil
+}
+
+// paramErrors are rejected request parameters, sendBadRequest lists them in
+// the response.
+type paramErrors []util.FieldError
+
+func (e paramErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = fmt.Sprintf("%s %q %s", f.Field, f.Value, f.Reason)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// sendBadRequest answers 400, naming the parameters when err holds paramErrors.
+func sendBadRequest(w http.ResponseWriter, err error) {
+	var fields paramErrors
+	if errors.As(err, &fields) {
+		util.SendFieldErrorJson(w, err.Error(), http.StatusBadRequest, fields...)
+		return
+	}
+	util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+}
+
+func fieldError(field, value string, err error) util.FieldError {
+	reason := err.Error()
+	var coordErr *geo.CoordinateError
+	if errors.As(err, &coordErr) {
+		reason = coordErr.Reason
+	}
+	return util.FieldError{Field: field, Value: value, Reason: reason}
+}
+
+// parseCoordinateParams reads a latitude and longitude in any format
+// geo.ParseLatitude knows, the longitude is wrapped into [-180, 180].
+func parseCoordinateParams(latField, lat, lonField, lon string) (location.Coordinates, error) {
+	var c location.Coordinates
+	var fields paramErrors
+	var err error
+
+	if c.Lat, err = geo.ParseLatitude(lat); err != nil {
+		fields = append(fields, fieldError(latField, lat, err))
+	}
+	if c.Lon, err = geo.ParseLongitude(lon); err != nil {
+		fields = append(fields, fieldError(lonField, lon, err))
+	}
+	if len(fields) > 0 {
+		return location.Coordinates{}, fields
+	}
+	return c, nil
+}
+
+func (server *Server) HandleLocationWithin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	center, err := parseCoordinateParams("lat", query.Get("lat"), "lon", query.Get("lon"))
+	if err != nil {
+		sendBadRequest(w, err)
+		return
+	}
+	values, err := parseFloatParams(query, "radius_km")
+	if err != nil {
+		sendBadRequest(w, err)
+		return
+	}
+	radiusKm := values[0]
+
+	if radiusKm <= 0 || radiusKm > maxAreaRadiusKm {
+		util.SendErrorJson(w, fmt.Sprintf("radius_km must be between 0 and %d", maxAreaRadiusKm), http.StatusBadRequest)
+		return
+	}
+
+	page, limit, err := parsePagination(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	country, err := parseCountryFilter(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := server.LocationService.FindWithin(r.Context(), center, radiusKm, country, page, limit)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to query locations", http.StatusInternalServerError)
+		return
+	}
+
+	sendPage(w, requestedFormat(r), result)
+}
+
+func (server *Server) HandleLocationBox(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	sw, swErr := parseCoordinateParams("minLat", query.Get("minLat"), "minLon", query.Get("minLon"))
+	ne, neErr := parseCoordinateParams("maxLat", query.Get("maxLat"), "maxLon", query.Get("maxLon"))
+	if swErr != nil || neErr != nil {
+		var fields paramErrors
+		for _, err := range []error{swErr, neErr} {
+			if f, ok := err.(paramErrors); ok {
+				fields = append(fields, f...)
 			}
-		}()
+		}
+		sendBadRequest(w, fields)
+		return
+	}
+	if sw.Lat > ne.Lat {
+		util.SendErrorJson(w, "minLat must not be greater than maxLat", http.StatusBadRequest)
+		return
+	}
+	if height, width := boxSpanKm(sw, ne); height > maxBoxSpanKm || width > maxBoxSpanKm {
+		var fields paramErrors
+		if height > maxBoxSpanKm {
+			fields = append(fields, util.FieldError{Field: "maxLat", Value: query.Get("maxLat"),
+				Reason: fmt.Sprintf("makes the box %.0f km high, at most %d km are allowed", height, maxBoxSpanKm)})
+		}
+		if width > maxBoxSpanKm {
+			fields = append(fields, util.FieldError{Field: "maxLon", Value: query.Get("maxLon"),
+				Reason: fmt.Sprintf("makes the box %.0f km wide, at most %d km are allowed", width, maxBoxSpanKm)})
+		}
+		sendBadRequest(w, fields)
+		return
 	}
 
-	feedJob := func(idx uint, setup func(*services.LocationResolveIn)) {
-		in := resolveInPool.Get().(*services.LocationResolveIn)
-		in.Reset()
-		setup(in)
-		wg.Add(1)
-		jobs <- job{index: idx, in: in}
+	page, limit, err := parsePagination(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	var currIdx uint
-	for _, c := range coords {
-		feedJob(currIdx, func(i *services.LocationResolveIn) { i.Coordinates = c })
-		currIdx++
+	country, err := parseCountryFilter(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	for _, a := range addresses {
-		feedJob(currIdx, func(i *services.LocationResolveIn) { i.LocationReadableAddress = a })
-		currIdx++
+
+	result, err := server.LocationService.FindInBox(r.Context(), sw, ne, country, page, limit)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to query locations", http.StatusInternalServerError)
+		return
 	}
-	for _, ip := range ips {
-		feedJob(currIdx, func(i *services.LocationResolveIn) { i.IP = ip })
-		currIdx++
+
+	sendPage(w, requestedFormat(r), result)
+}
+
+// boxSpanKm measures a box north to south and west to east along its widest
+// parallel, the one nearest the equator. A box with sw.Lon > ne.Lon crosses the antimeridian.
+func boxSpanKm(sw, ne location.Coordinates) (height, width float64) {
+	height = (ne.Lat - sw.Lat) * math.Pi / 180 * geo.EarthRadiusKm
+
+	lonSpan := ne.Lon - sw.Lon
+	if lonSpan < 0 {
+		lonSpan += 360
+	}
+	widest := 0.0
+	if sw.Lat > 0 {
+		widest = sw.Lat
+	} else if ne.Lat < 0 {
+		widest = ne.Lat
 	}
+	width = lonSpan * math.Pi / 180 * geo.EarthRadiusKm * math.Cos(widest*math.Pi/180)
+	return height, width
+}
 
-	close(jobs)
-	wg.Wait()
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// HandleLocationSuggest completes a partly typed name for location pickers.
+// Matches are ranked by distance from the caller when their position is known.
+func (server *Server) HandleLocationSuggest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-	util.SendJson(w, http.StatusOK, slices.DeleteFunc(finalData, func(r any) bool { return r == nil }))
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		util.SendErrorJson(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSuggestLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxSuggestLimit {
+			util.SendErrorJson(w, fmt.Sprintf("limit must be between 1 and %d", maxSuggestLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	country, err := parseCountryFilter(query)
+	if err != nil {
+		util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	near, err := server.callerPosition(r)
+	if err != nil {
+		sendBadRequest(w, err)
+		return
+	}
+
+	result, err := server.LocationService.Suggest(r.Context(), q, country, near, limit)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to query locations", http.StatusInternalServerError)
+		return
+	}
+
+	sendPage(w, requestedFormat(r), result)
+}
+
+type weatherResponse struct {
+	Location any                  `json:"location"`
+	Weather  *weather.WeatherData `json:"weather"`
 }
 
+// HandleWeather serves one location given as location_id, lat/lon, city/state/country, zip/country or ip.
+// Without any of them the caller's own address is used.
 func (server *Server) HandleWeather(w http.ResponseWriter, r *http.Request) {
-	util.SendErrorJson(w, "Not implemented yet", http.StatusNotImplemented)
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	in := resolveInPool.Get().(*services.LocationResolveIn)
+	defer resolveInPool.Put(in)
+	in.Reset()
+
+	latParam, lonParam := query.Get("lat"), query.Get("lon")
+	cityParam, countryParam := query.Get("city"), query.Get("country")
+	zipParam := query.Get("zip")
+
+	switch {
+	case query.Get("location_id") != "":
+		id, err := parseLocationID(query.Get("location_id"))
+		if err != nil {
+			util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.LocationID = id
+	case latParam != "" || lonParam != "":
+		c, err := parseCoordinateParams("lat", latParam, "lon", lonParam)
+		if err != nil {
+			sendBadRequest(w, err)
+			return
+		}
+		in.SetCoordinates(c)
+	case cityParam != "" && countryParam != "":
+		addr, err := location.NewReadableAddress(cityParam, query.Get("state"), countryParam)
+		if err != nil {
+			util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.LocationReadableAddress = addr
+	case zipParam != "" && countryParam != "":
+		addr, err := location.NewPostalAddress(zipParam, countryParam)
+		if err != nil {
+			util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.LocationReadableAddress = addr
+	default:
+		ipParam := query.Get("ip")
+		if ipParam == "" {
+			ipParam = "me"
+		}
+		ips, err := server.parseIPs(r, ipParam)
+		if err != nil {
+			util.SendErrorJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(ips) != 1 {
+			util.SendErrorJson(w, "weather takes a single ip", http.StatusBadRequest)
+			return
+		}
+		in.IP = ips[0]
+	}
+
+	res, jsonBytes, err := server.LocationService.ResolveLocation(ctx, in)
+	if err != nil {
+		util.SendErrorJson(w, "Location not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := server.WeatherService.GetWeatherData(ctx, res.Coordinates)
+	if err != nil {
+		util.SendErrorJson(w, "Failed to fetch weather data", http.StatusBadGateway)
+		return
+	}
+
+	resp := weatherResponse{Location: res, Weather: data}
+	if jsonBytes != nil {
+		resp.Location = json.RawMessage(jsonBytes)
+	}
+	util.SendJson(w, http.StatusOK, resp)
 }
 
 func (server *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
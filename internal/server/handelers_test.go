@@ -0,0 +1,32 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+func TestBoxSpanKm(t *testing.T) {
+	tests := []struct {
+		name          string
+		sw, ne        location.Coordinates
+		height, width float64
+	}{
+		{"Degree At The Equator", location.Coordinates{Lat: 0, Lon: 0}, location.Coordinates{Lat: 1, Lon: 1}, 111.2, 111.2},
+		{"Widest Edge Of A Northern Box", location.Coordinates{Lat: 60, Lon: 0}, location.Coordinates{Lat: 61, Lon: 1}, 111.2, 55.6},
+		{"Widest Edge Of A Southern Box", location.Coordinates{Lat: -61, Lon: 0}, location.Coordinates{Lat: -60, Lon: 1}, 111.2, 55.6},
+		{"Across The Equator", location.Coordinates{Lat: -60, Lon: 0}, location.Coordinates{Lat: 60, Lon: 1}, 13343.4, 111.2},
+		{"Across The Antimeridian", location.Coordinates{Lat: 0, Lon: 179.5}, location.Coordinates{Lat: 1, Lon: -179.5}, 111.2, 111.2},
+		{"Whole World", location.Coordinates{Lat: -90, Lon: -180}, location.Coordinates{Lat: 90, Lon: 180}, 20015.1, 40030.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			height, width := boxSpanKm(tt.sw, tt.ne)
+			if math.Abs(height-tt.height) > 0.1 || math.Abs(width-tt.width) > 0.1 {
+				t.Errorf("boxSpanKm() = %.1f, %.1f, want %.1f, %.1f", height, width, tt.height, tt.width)
+			}
+		})
+	}
+}
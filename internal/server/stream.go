@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/sonic"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// ndjsonStream writes one JSON document per line and flushes after each, it is
+// safe to use from several workers at once.
+type ndjsonStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	enc     sonic.Encoder
+}
+
+func newNDJSONStream(w http.ResponseWriter) *ndjsonStream {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	s := &ndjsonStream{
+		w:       w,
+		flusher: flusher,
+		enc:     sonic.ConfigDefault.NewEncoder(w),
+	}
+	s.flush()
+	return s
+}
+
+func (s *ndjsonStream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+func (s *ndjsonStream) Write(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
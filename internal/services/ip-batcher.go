@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/7apri/SimpleGOWebserver/internal/api"
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+type ipBatchResult struct {
+	res *location.IpGeoResult
+	err error
+}
+
+// IPBatcher collects IP lookups arriving within window and sends them to ip-api
+// as one batch call, each caller then gets its own answer back.
+type IPBatcher struct {
+	client  *api.IpApiClient
+	window  time.Duration
+	maxSize int
+
+	mu      sync.Mutex
+	order   []string
+	pending map[string][]chan ipBatchResult
+	timer   *time.Timer
+}
+
+func NewIPBatcher(client *api.IpApiClient, window time.Duration, maxSize int) *IPBatcher {
+	return &IPBatcher{
+		client:  client,
+		window:  window,
+		maxSize: min(max(maxSize, 1), api.IpApiMaxBatch),
+		pending: make(map[string][]chan ipBatchResult),
+	}
+}
+
+func (b *IPBatcher) IpToCoordinates(ctx context.Context, ip string) (*location.IpGeoResult, error) {
+	ch := make(chan ipBatchResult, 1)
+
+	b.mu.Lock()
+	if _, queued := b.pending[ip]; !queued {
+		b.order = append(b.order, ip)
+	}
+	b.pending[ip] = append(b.pending[ip], ch)
+
+	if len(b.order) >= b.maxSize {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	b.mu.Unlock()
+
+	select {
+	case r := <-ch:
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *IPBatcher) flush() {
+	b.mu.Lock()
+	b.flushLocked()
+	b.mu.Unlock()
+}
+
+func (b *IPBatcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.order) == 0 {
+		return
+	}
+
+	ips, waiters := b.order, b.pending
+	b.order = nil
+	b.pending = make(map[string][]chan ipBatchResult)
+
+	go b.run(ips, waiters)
+}
+
+func (b *IPBatcher) run(ips []string, waiters map[string][]chan ipBatchResult) {
+	// the batch outlives any single caller, so it gets its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var results []*location.IpGeoResult
+	var err error
+	if len(ips) == 1 {
+		var res *location.IpGeoResult
+		res, err = b.client.IpToCoordinates(ctx, ips[0])
+		results = []*location.IpGeoResult{res}
+	} else {
+		results, err = b.client.IpsToCoordinates(ctx, ips)
+	}
+
+	for i, ip := range ips {
+		r := ipBatchResult{err: err}
+		if err == nil {
+			if results[i] == nil {
+				r.err = fmt.Errorf("ip geo failed for: %s", ip)
+			} else {
+				r.res = results[i]
+			}
+		}
+
+		for _, ch := range waiters[ip] {
+			ch <- r
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+// invalidate drops every cached answer that points at one of the given rows,
+// whichever key (address, coordinates, ip or id) it was cached under.
+func (lS *LocationService) invalidate(locs ...*location.GeoResult) {
+	if len(locs) == 0 {
+		return
+	}
+
+	stale := func(val *location.GeoResult) bool {
+		for _, loc := range locs {
+			if val.ID != 0 && val.ID == loc.ID {
+				return true
+			}
+			if val.CityName == loc.CityName && val.State == loc.State && val.Country == loc.Country {
+				return true
+			}
+		}
+		return false
+	}
+
+	// resolves still in flight read the rows before the change, they see the
+	// new generation and leave the caches alone. What they added before is removed below.
+	lS.genMu.Lock()
+	lS.generation.Add(1)
+	lS.genMu.Unlock()
+
+	lS.cache.RemoveIf(func(key string, val *location.GeoResult) bool {
+		return stale(val)
+	})
+	for _, ip := range lS.ipCache.Keys() {
+		if cached, ok := lS.ipCache.Peek(ip); ok && stale(cached.result) {
+			lS.ipCache.Remove(ip)
+		}
+	}
+}
+
+func (lS *LocationService) CreateLocation(ctx context.Context, loc *location.GeoResult) (*location.GeoResult, error) {
+	created, err := lS.DB.CreateLocation(ctx, loc)
+	if err != nil {
+		return nil, err
+	}
+	// answers cached for the same address before the row existed are now stale
+	lS.invalidate(created)
+	return created, nil
+}
+
+func (lS *LocationService) UpdateLocation(ctx context.Context, id int64, loc *location.GeoResult) (*location.GeoResult, error) {
+	before, after, err := lS.DB.UpdateLocation(ctx, id, loc)
+	if err != nil {
+		return nil, err
+	}
+	lS.invalidate(before, after)
+	return after, nil
+}
+
+func (lS *LocationService) DeleteLocation(ctx context.Context, id int64) error {
+	deleted, err := lS.DB.DeleteLocation(ctx, id)
+	if err != nil {
+		return err
+	}
+	lS.invalidate(deleted)
+	return nil
+}
+
+func (lS *LocationService) MergeLocations(ctx context.Context, targetID int64, sourceIDs []int64) (*location.GeoResult, error) {
+	affected, err := lS.DB.MergeLocations(ctx, targetID, sourceIDs)
+	if err != nil {
+		return nil, err
+	}
+	lS.invalidate(affected...)
+
+	return lS.DB.FindLocationByID(ctx, targetID)
+}
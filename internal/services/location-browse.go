@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/7apri/SimpleGOWebserver/internal/location"
+)
+
+// countsTTL bounds how stale the per-country and per-state totals may get,
+// counting a full gazetteer on every picker request is too slow.
+const countsTTL = 10 * time.Minute
+
+type cachedCounts struct {
+	counts    map[string]int64
+	fetchedAt time.Time
+}
+
+// CountryCounts returns the number of stored locations per country code.
+func (lS *LocationService) CountryCounts(ctx context.Context) (map[string]int64, error) {
+	return lS.cachedCounts(ctx, "", func() (map[string]int64, error) {
+		return lS.DB.CountLocationsByCountry(ctx)
+	})
+}
+
+// StateCounts returns the number of stored locations per state of country.
+func (lS *LocationService) StateCounts(ctx context.Context, country string) (map[string]int64, error) {
+	return lS.cachedCounts(ctx, country, func() (map[string]int64, error) {
+		return lS.DB.CountLocationsByState(ctx, country)
+	})
+}
+
+func (lS *LocationService) cachedCounts(ctx context.Context, key string, load func() (map[string]int64, error)) (map[string]int64, error) {
+	lS.countsMu.Lock()
+	cached, ok := lS.counts[key]
+	lS.countsMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < countsTTL {
+		return cached.counts, nil
+	}
+
+	val, err, _ := lS.sfG.Do("n:"+key, func() (any, error) {
+		counts, err := load()
+		if err != nil {
+			return nil, err
+		}
+		lS.countsMu.Lock()
+		lS.counts[key] = cachedCounts{counts: counts, fetchedAt: time.Now()}
+		lS.countsMu.Unlock()
+		return counts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(map[string]int64), nil
+}
+
+// FindInRegion pages through the locations of a country, or of one of its
+// states, the most populated first.
+func (lS *LocationService) FindInRegion(ctx context.Context, country, state string, page, limit int) (*location.GeoResultPage, error) {
+	results, err := lS.DB.FindLocationsInRegion(ctx, country, location.CanonicalState(country, state), limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoResultPage(results, page, limit), nil
+}
@@ -2,37 +2,106 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hash/maphash"
 	"log/slog"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/7apri/SimpleGOWebserver/internal/api"
 	"github.com/7apri/SimpleGOWebserver/internal/cache"
 	"github.com/7apri/SimpleGOWebserver/internal/database"
+	"github.com/7apri/SimpleGOWebserver/internal/geo"
+	"github.com/7apri/SimpleGOWebserver/internal/geohash"
 	"github.com/7apri/SimpleGOWebserver/internal/location"
+	util "github.com/7apri/SimpleGOWebserver/pkg"
 
 	"github.com/bytedance/sonic"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jackc/pgx/v5"
 	"golang.org/x/sync/singleflight"
 )
 
+// cachedIP is an ip answer in memory, it expires ipCacheTTL after fetchedAt
+// like its ip_geolocation row.
+type cachedIP struct {
+	result    *location.GeoResult
+	fetchedAt time.Time
+}
+
+type saveTask struct {
+	loc      *location.GeoResult
+	ip       string
+	ipSource string
+}
+
 type LocationService struct {
 	DB        *database.Database
 	cache     *cache.TieredCache[*location.GeoResult, string]
+	ipCache   *lru.Cache[string, cachedIP]
 	sfG       singleflight.Group
-	saveQueue chan *location.GeoResult
-	owClient  *api.OpenWeatherClient
-	ipClient  *api.IpApiClient
+	saveQueue chan saveTask
+	geocoder  api.Geocoder
+	ipClient  api.IPGeolocator
 	wg        sync.WaitGroup
+
+	countsMu sync.Mutex
+	counts   map[string]cachedCounts
+
+	// generation counts invalidations, a resolve started before one must not
+	// cache what it read. genMu orders the check and the add against invalidate.
+	generation atomic.Uint64
+	genMu      sync.RWMutex
+
+	maxMatchRadiusKm float64
+	ipCacheTTL       time.Duration
+	cellPrecision    int
+}
+
+// OutputFormat selects which pre-encoded form ResolveLocation hands back,
+// the values index the encoders of the location cache.
+type OutputFormat int
+
+const (
+	FormatJSON OutputFormat = iota
+	FormatGeoJSON
+)
+
+// ResolveSource tells where ResolveLocation found its answer.
+type ResolveSource string
+
+const (
+	SourceCache    ResolveSource = "cache"
+	SourceDB       ResolveSource = "db"
+	SourceUpstream ResolveSource = "upstream"
+)
+
+type resolved struct {
+	result *location.GeoResult
+	source ResolveSource
+	// byCoordinates marks a result looked up for the cell of the coordinates,
+	// its distance is added per caller since the cell shares it.
+	byCoordinates bool
 }
 
 type LocationResolveIn struct {
 	location.FullAddress
-	IP        string `json:"ip,omitempty"`
-	builder   strings.Builder
-	cachedKey atomic.Pointer[string]
+	IP         string `json:"ip,omitempty"`
+	LocationID int64  `json:"location_id,omitempty"`
+	// HasCoordinates marks Coordinates as given, 0,0 is a valid position.
+	HasCoordinates bool `json:"-"`
+	// Near is the caller's position, it ranks places sharing a name when the
+	// address has no country.
+	Near   *location.Coordinates `json:"near,omitempty"`
+	Format OutputFormat          `json:"-"`
+	Source ResolveSource         `json:"-"` // filled in by ResolveLocation
+	// cellPrecision is the geohash precision of coordinate keys, set by ResolveLocation
+	cellPrecision int
+	builder       strings.Builder
+	cachedKey     atomic.Pointer[string]
 }
 
 func (l *LocationResolveIn) Reset() {
@@ -41,7 +110,13 @@ func (l *LocationResolveIn) Reset() {
 	l.CityName = ""
 	l.Country = ""
 	l.State = ""
+	l.PostalCode = ""
 	l.IP = ""
+	l.LocationID = 0
+	l.Near = nil
+	l.HasCoordinates = false
+	l.Format = FormatJSON
+	l.Source = ""
 	l.Lat = 0
 	l.Lon = 0
 }
@@ -54,18 +129,21 @@ func (lR *LocationResolveIn) Key() string {
 	lR.builder.Reset()
 	lR.builder.Grow(32)
 
-	if lR.CityName != "" && lR.Country != "" {
-		lR.LocationReadableAddress.WriteKey(&lR.builder)
-	}
-	if lR.Lat != 0 || lR.Lon != 0 {
-		lR.Coordinates.WriteKey(&lR.builder)
+	// an id names exactly one row, nothing else in the input matters
+	if lR.LocationID != 0 {
+		finalStr := location.IDKey(lR.LocationID)
+		lR.cachedKey.Store(&finalStr)
+		return finalStr
 	}
 
-	if lR.IP != "" {
-		lR.builder.WriteString("i:")
-		lR.builder.WriteString(lR.IP)
+	// a postal code is more precise than the city it may come with
+	if lR.PostalCode != "" && lR.Country != "" {
+		finalStr := location.PostalKey(lR.Country, lR.PostalCode)
+		lR.cachedKey.Store(&finalStr)
+		return finalStr
 	}
 
+	lR.writeLookupKey(&lR.builder)
 	finalStr := lR.builder.String()
 
 	lR.cachedKey.Store(&finalStr)
@@ -73,55 +151,215 @@ func (lR *LocationResolveIn) Key() string {
 	return finalStr
 }
 
+// writeLookupKey writes the key of the address, coordinate and ip lookup,
+// the part of Key that does not depend on a postal code.
+func (lR *LocationResolveIn) writeLookupKey(b *strings.Builder) {
+	if lR.CityName != "" {
+		lR.writeAddressKey(b)
+	}
+	if lR.HasCoordinates {
+		lR.Coordinates.WriteKey(b, lR.cellPrecision)
+	}
+
+	if lR.IP != "" {
+		b.WriteString("i:")
+		b.WriteString(lR.IP)
+	}
+}
+
+func (lR *LocationResolveIn) lookupKey() string {
+	var b strings.Builder
+	lR.writeLookupKey(&b)
+	return b.String()
+}
+
+// ipAnswerKey keys the address and cell an ip provider answered with like a
+// caller giving both, the ip itself left out.
+func (lR *LocationResolveIn) ipAnswerKey() string {
+	var b strings.Builder
+	if lR.CityName != "" {
+		lR.writeAddressKey(&b)
+	}
+	lR.Coordinates.WriteKey(&b, lR.cellPrecision)
+	return b.String()
+}
+
+// SetCoordinates fills in the coordinates and marks them as given.
+func (lR *LocationResolveIn) SetCoordinates(c location.Coordinates) {
+	lR.Coordinates = c
+	lR.HasCoordinates = true
+}
+
+// writeAddressKey keys an address without a country by the area it was asked
+// from too, since the answer depends on it.
+func (lR *LocationResolveIn) writeAddressKey(b *strings.Builder) {
+	lR.LocationReadableAddress.WriteKey(b)
+	if lR.Country == "" && lR.Near != nil {
+		b.WriteString("n:")
+		b.WriteString(geohash.Encode(lR.Near.Lat, lR.Near.Lon, nearCellPrecision))
+	}
+}
+
+// nearCellPrecision groups callers into cells of about 39 x 20 km, close
+// enough to tell apart places sharing a name.
+const nearCellPrecision = 4
+
+// nearCell snaps the caller's position like its key in writeAddressKey.
+func nearCell(near *location.Coordinates) *location.Coordinates {
+	if near == nil {
+		return nil
+	}
+	center := near.CellCenter(nearCellPrecision)
+	return &center
+}
+
+func (lR *LocationResolveIn) addressKey() string {
+	var b strings.Builder
+	lR.writeAddressKey(&b)
+	return b.String()
+}
+
 func (lR *LocationResolveIn) ResetKey() {
 	lR.cachedKey.Store(nil)
 }
 
 func (lS *LocationService) ResolveLocation(ctx context.Context, locationIn *LocationResolveIn) (*location.GeoResult, []byte, error) {
-	if data, jsonBytes, ok := lS.cache.Get(locationIn.Key()); ok {
+	gen := lS.generation.Load()
+
+	if locationIn.cellPrecision != lS.cellPrecision {
+		locationIn.cellPrecision = lS.cellPrecision
+		locationIn.ResetKey()
+	}
+
+	if data, jsonBytes, ok := lS.cache.GetEncoded(locationIn.Key(), int(locationIn.Format)); ok {
+		locationIn.Source = SourceCache
+		if locationIn.HasCoordinates && locationIn.CityName == "" {
+			// the pre-encoded bytes lack the distance from this caller
+			return withDistanceFrom(locationIn.Coordinates, data), nil, nil
+		}
 		return data, jsonBytes, nil
 	}
 
+	if locationIn.LocationID != 0 {
+		val, flightGen, err := lS.do(gen, locationIn.Key(), func() (any, error) {
+			return lS.DB.FindLocationByID(ctx, locationIn.LocationID)
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("location %d not found", locationIn.LocationID)
+		}
+
+		result := val.(*location.GeoResult)
+		lS.addCurrent(flightGen, func() { lS.cache.Add(locationIn.Key(), result) })
+		locationIn.Source = SourceDB
+		return result, nil, nil
+	}
+
+	if locationIn.PostalCode != "" {
+		result, source, err := lS.resolvePostalCode(ctx, gen, locationIn)
+		if err == nil {
+			locationIn.Source = source
+			return result, nil, nil
+		}
+		if locationIn.CityName == "" {
+			return nil, nil, err
+		}
+	}
+
+	var ipSource, ipTimezone string
 	if locationIn.IP != "" && locationIn.CityName == "" {
-		val, err, _ := lS.sfG.Do("i:"+locationIn.IP, func() (any, error) {
+		if cached, ok := lS.ipCache.Get(locationIn.IP); ok && time.Since(cached.fetchedAt) < lS.ipCacheTTL {
+			locationIn.Source = SourceCache
+			return cached.result, nil, nil
+		}
+
+		val, flightGen, err := lS.do(gen, "i:"+locationIn.IP, func() (any, error) {
+			if known, fetchedAt, err := lS.DB.FindLocationByIP(ctx, locationIn.IP, lS.ipCacheTTL); err == nil {
+				return cachedIP{result: known, fetchedAt: fetchedAt}, nil
+			}
 			return lS.ipClient.IpToCoordinates(ctx, locationIn.IP)
 		})
 		if err == nil {
-			ipRes := val.(*location.IpGeoResult)
-			locationIn.LocationReadableAddress = ipRes.GetAddress()
-			locationIn.Coordinates = ipRes.Coordinates
+			gen = flightGen
+			switch res := val.(type) {
+			case cachedIP:
+				lS.addCurrent(gen, func() { lS.ipCache.Add(locationIn.IP, res) })
+				locationIn.Source = SourceDB
+				return res.result, nil, nil
+			case *location.IpGeoResult:
+				ipSource = res.Source
+				ipTimezone = res.Timezone
+				// stored names are cleaned, provider names are not
+				addr := res.GetAddress()
+				addr.CityName = util.CleanQuery(addr.CityName)
+				addr.State = location.CanonicalState(addr.Country, addr.State)
+				locationIn.LocationReadableAddress = addr
+				locationIn.SetCoordinates(res.Coordinates)
 
-			locationIn.ResetKey()
+				locationIn.ResetKey()
 
-			if data, jsonBytes, ok := lS.cache.Get(locationIn.Key()); ok {
-				return data, jsonBytes, nil
+				// the answer is looked up under the keys the resolve below stores
+				keys := []string{locationIn.Coordinates.Key(lS.cellPrecision)}
+				if locationIn.CityName != "" {
+					keys = append([]string{locationIn.addressKey()}, keys...)
+				}
+				for _, key := range keys {
+					if data, jsonBytes, ok := lS.cache.GetEncoded(key, int(locationIn.Format)); ok {
+						lS.rememberIP(gen, locationIn.IP, ipSource, data)
+						locationIn.Source = ipLookupSource(ipSource, SourceCache)
+						return data, jsonBytes, nil
+					}
+				}
 			}
 		}
 	}
 
-	val, err, _ := lS.sfG.Do(locationIn.Key(), func() (any, error) {
+	flightKey := locationIn.Key()
+	switch {
+	case ipSource != "":
+		// callers whose ips the provider places in the same city share one flight
+		flightKey = locationIn.ipAnswerKey()
+	case locationIn.PostalCode != "":
+		// a postal code the city fell back from must not share the flight of resolvePostalCode
+		flightKey = locationIn.lookupKey()
+	}
+
+	val, gen, err := lS.do(gen, flightKey, func() (any, error) {
 		var result *location.GeoResult
 		var err error
+		source := SourceDB
+		byCoordinates := false
 
 		if locationIn.CityName != "" {
-			result, err = lS.DB.FindLocationByAddress(ctx, &locationIn.LocationReadableAddress)
+			result, err = lS.DB.FindLocationByAddress(ctx, &locationIn.LocationReadableAddress, nearCell(locationIn.Near))
 			if err != nil {
 				slog.Error("got an err", "err", err)
-				/*data, apiErr := lS.owClient.Geolocate(ctx, &locationIn.LocationReadableAddress)
+				/*data, apiErr := lS.geocoder.Geolocate(ctx, &locationIn.LocationReadableAddress)
 				if apiErr == nil && len(data) > 0 {
 					result = &data[0]
 					lS.wg.Add(1)
 					lS.saveQueue <- result
 				}*/
 			}
-		} else if locationIn.Lat != 0 {
-			result, err = lS.DB.FindLocationByCoords(ctx, &locationIn.Coordinates)
-			if err != nil {
-				data, apiErr := lS.owClient.ReverseGeolocate(ctx, &locationIn.Coordinates)
+		}
+		// ip results carry coordinates too, use them when the address is unknown.
+		// The whole cell is answered from its center, as the cache shares it.
+		if result == nil && locationIn.HasCoordinates {
+			center := locationIn.Coordinates.CellCenter(lS.cellPrecision)
+			byCoordinates = true
+			result, err = lS.DB.FindLocationByCoords(ctx, &center, lS.maxMatchRadiusKm)
+			if err == nil {
+				result = result.WithoutDistance()
+			} else {
+				data, apiErr := lS.geocoder.ReverseGeolocate(ctx, &center)
 				if apiErr == nil && len(data) > 0 {
-					result = &data[0]
-					lS.wg.Add(1)
-					lS.saveQueue <- result
+					// saved right away so the response can carry the new id
+					id, saveErr := lS.DB.SaveLocation(ctx, &data[0])
+					if saveErr != nil {
+						slog.Error("failed to save location", "error", saveErr)
+					}
+					data[0].ID = id
+					result = data[0].WithoutDistance()
+					source = SourceUpstream
 				}
 			}
 		}
@@ -129,24 +367,191 @@ func (lS *LocationService) ResolveLocation(ctx context.Context, locationIn *Loca
 		if result == nil {
 			return nil, fmt.Errorf("location not found")
 		}
-		return result, nil
+		return resolved{result: result, source: source, byCoordinates: byCoordinates}, nil
 	})
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	finalResult := val.(*location.GeoResult)
+	r := val.(resolved)
+	finalResult := r.result
+	locationIn.Source = ipLookupSource(ipSource, r.source)
 
-	lS.cache.Add(locationIn.Coordinates.Key(), finalResult)
-	lS.cache.Add(locationIn.LocationReadableAddress.Key(), finalResult)
+	// ip providers know the timezone, OpenWeather does not
+	if finalResult.Timezone == "" && ipTimezone != "" {
+		cp := *finalResult
+		cp.Timezone = ipTimezone
+		finalResult = &cp
+	}
+
+	// cached entries carry no distance, it is only meaningful relative to one caller
+	lS.addCurrent(gen, func() {
+		if locationIn.HasCoordinates {
+			lS.cache.Add(locationIn.Coordinates.Key(lS.cellPrecision), finalResult.WithoutDistance())
+		}
+		if locationIn.CityName != "" {
+			lS.cache.Add(locationIn.addressKey(), finalResult.WithoutDistance())
+		}
+		if finalResult.ID != 0 {
+			lS.cache.Add(location.IDKey(finalResult.ID), finalResult.WithoutDistance())
+		}
+	})
+	if ipSource != "" {
+		lS.rememberIP(gen, locationIn.IP, ipSource, finalResult)
+	}
 
+	if r.byCoordinates {
+		return withDistanceFrom(locationIn.Coordinates, finalResult), nil, nil
+	}
 	return finalResult, nil, nil
 }
 
+// withDistanceFrom copies a result shared by a whole cell with the distance
+// from the caller's own point.
+func withDistanceFrom(from location.Coordinates, result *location.GeoResult) *location.GeoResult {
+	return result.WithDistance(geo.Haversine(from, result.Coordinates))
+}
+
+// resolvePostalCode answers from postal_codes and asks the geocoder for codes it
+// has not seen, storing the place and the code right away.
+func (lS *LocationService) resolvePostalCode(ctx context.Context, gen uint64, locationIn *LocationResolveIn) (*location.GeoResult, ResolveSource, error) {
+	country, code := locationIn.Country, locationIn.PostalCode
+	key := location.PostalKey(country, code)
+
+	val, gen, err := lS.do(gen, key, func() (any, error) {
+		known, err := lS.DB.FindLocationByPostalCode(ctx, country, code)
+		if err == nil {
+			return resolved{result: known, source: SourceDB}, nil
+		}
+		// only unknown codes go to the paid lookup, not a database failure
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+
+		result, err := lS.geocoder.GeolocateZip(ctx, code, country)
+		if err != nil {
+			return nil, err
+		}
+		id, err := lS.DB.SaveLocation(ctx, result)
+		if err != nil {
+			return nil, err
+		}
+		result.ID = id
+		if err := lS.DB.SavePostalCode(ctx, country, code, id); err != nil {
+			slog.Error("failed to save postal code", "error", err)
+		}
+		return resolved{result: result, source: SourceUpstream}, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	r := val.(resolved)
+	lS.addCurrent(gen, func() { lS.cache.Add(key, r.result) })
+	return r.result, r.source, nil
+}
+
+// do is sfG.Do that also returns the older of gen and the generation the
+// flight started at. A caller joining a flight gets what that flight read.
+func (lS *LocationService) do(gen uint64, key string, fn func() (any, error)) (any, uint64, error) {
+	type started struct {
+		val any
+		gen uint64
+	}
+	v, err, _ := lS.sfG.Do(key, func() (any, error) {
+		flightGen := lS.generation.Load()
+		val, err := fn()
+		return started{val: val, gen: flightGen}, err
+	})
+	s, _ := v.(started)
+	return s.val, min(gen, s.gen), err
+}
+
+// addCurrent runs add, which fills the caches, only when no invalidation ran
+// since the resolve started at generation gen. Otherwise what it read may be a
+// row that was changed or merged away since.
+func (lS *LocationService) addCurrent(gen uint64, add func()) {
+	lS.genMu.RLock()
+	defer lS.genMu.RUnlock()
+	if lS.generation.Load() == gen {
+		add()
+	}
+}
+
+// ipLookupSource reports a paid ip provider call as upstream even when the
+// location itself was already known.
+func ipLookupSource(ipSource string, locationSource ResolveSource) ResolveSource {
+	if ipSource == api.IpApiSource {
+		return SourceUpstream
+	}
+	return locationSource
+}
+
+// rememberIP caches an upstream ip answer in memory and in ip_geolocation so the
+// next lookup of the same address skips the provider until the TTL runs out.
+func (lS *LocationService) rememberIP(gen uint64, ip, source string, result *location.GeoResult) {
+	result = result.WithoutDistance()
+	lS.addCurrent(gen, func() { lS.ipCache.Add(ip, cachedIP{result: result, fetchedAt: time.Now()}) })
+
+	lS.wg.Add(1)
+	lS.saveQueue <- saveTask{loc: result, ip: ip, ipSource: source}
+}
+
+func (lS *LocationService) FindWithin(ctx context.Context, center location.Coordinates, radiusKm float64, country string, page, limit int) (*location.GeoResultPage, error) {
+	results, err := lS.DB.FindLocationsWithin(ctx, &center, radiusKm, country, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoResultPage(results, page, limit), nil
+}
+
+func (lS *LocationService) FindInBox(ctx context.Context, sw, ne location.Coordinates, country string, page, limit int) (*location.GeoResultPage, error) {
+	results, err := lS.DB.FindLocationsInBox(ctx, &sw, &ne, country, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoResultPage(results, page, limit), nil
+}
+
+// Suggest completes a partly typed place name, near ranks the matches by
+// distance when it is set.
+func (lS *LocationService) Suggest(ctx context.Context, prefix, country string, near *location.Coordinates, limit int) (*location.GeoResultPage, error) {
+	results, err := lS.DB.SuggestLocations(ctx, util.CleanQuery(prefix), country, near, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoResultPage(results, 1, limit), nil
+}
+
+// newGeoResultPage expects results to be fetched with limit+1 rows so the
+// extra row tells whether another page exists.
+func newGeoResultPage(results []location.GeoResult, page, limit int) *location.GeoResultPage {
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+	return &location.GeoResultPage{
+		Results: results,
+		PageInfo: location.PageInfo{
+			Page:    page,
+			Limit:   limit,
+			HasMore: hasMore,
+		},
+	}
+}
+
 func (lS *LocationService) locationSaver() {
-	for location := range lS.saveQueue {
-		lS.DB.SaveLocation(location)
+	for task := range lS.saveQueue {
+		var err error
+		if task.ip != "" {
+			err = lS.DB.SaveIPLocation(context.Background(), task.ip, task.ipSource, task.loc)
+		} else {
+			_, err = lS.DB.SaveLocation(context.Background(), task.loc)
+		}
+		if err != nil {
+			slog.Error("failed to save location", "error", err)
+		}
 		lS.wg.Done()
 	}
 }
@@ -156,20 +561,32 @@ func (lS *LocationService) Down() {
 	lS.wg.Wait()
 }
 
-func NewLocationService(db *database.Database, cacheSize int, apiKey string, owClient *api.OpenWeatherClient, ipClient *api.IpApiClient) (*LocationService, error) {
+func NewLocationService(db *database.Database, cacheSize int, apiKey string, geocoder api.Geocoder, ipClient api.IPGeolocator, maxMatchRadiusKm float64, ipCacheTTL time.Duration, cellPrecision int) (*LocationService, error) {
 	s := maphash.MakeSeed()
 	c := cache.NewTieredCache(cacheSize, 16, 20, 1000,
-		func(data *location.GeoResult) ([]byte, error) {
-			return sonic.Marshal(data)
+		[]func(*location.GeoResult) ([]byte, error){
+			FormatJSON: func(data *location.GeoResult) ([]byte, error) {
+				return sonic.Marshal(data)
+			},
+			FormatGeoJSON: func(data *location.GeoResult) ([]byte, error) {
+				return sonic.Marshal(data.Feature())
+			},
 		}, func(key string) uint32 {
 			return uint32(maphash.String(s, key))
 		})
+	ipCache, _ := lru.New[string, cachedIP](cacheSize)
 	service := LocationService{
 		DB:        db,
 		cache:     c,
-		saveQueue: make(chan *location.GeoResult, 100),
-		owClient:  owClient,
+		ipCache:   ipCache,
+		saveQueue: make(chan saveTask, 100),
+		geocoder:  geocoder,
 		ipClient:  ipClient,
+		counts:    make(map[string]cachedCounts),
+
+		maxMatchRadiusKm: maxMatchRadiusKm,
+		ipCacheTTL:       ipCacheTTL,
+		cellPrecision:    cellPrecision,
 	}
 	go service.locationSaver()
 
@@ -1,9 +1,13 @@
 package services
 
 import (
+	"context"
+	"time"
+
 	lru "github.com/hashicorp/golang-lru/v2"
 	"golang.org/x/sync/singleflight"
 
+	"github.com/7apri/SimpleGOWebserver/internal/api"
 	"github.com/7apri/SimpleGOWebserver/internal/database"
 	"github.com/7apri/SimpleGOWebserver/internal/location"
 	"github.com/7apri/SimpleGOWebserver/internal/weather"
@@ -14,11 +18,48 @@ type WeatherServicePayload struct {
 	weatherData *weather.WeatherData
 }
 
+// weatherFetchTimeout bounds one shared OpenWeather call, matching its HTTP client.
+const weatherFetchTimeout = 10 * time.Second
+
+type cachedWeather struct {
+	data      *weather.WeatherData
+	fetchedAt time.Time
+}
+
 type WeatherService struct {
 	*database.Database
 	cache     *lru.Cache[uint, *weather.WeatherData]
 	sfG       singleflight.Group
 	saveQueue chan *WeatherServicePayload
+	owClient  *api.OpenWeatherClient
+	// cellCache holds the weather of a geohash cell of cellPrecision for maxAge
+	cellCache     *lru.Cache[string, cachedWeather]
+	cellPrecision int
+	maxAge        time.Duration
+}
+
+// GetWeatherData answers every point of a cell with the weather of its center,
+// fetched from OpenWeather at most once per maxAge.
+func (wS *WeatherService) GetWeatherData(ctx context.Context, coords location.Coordinates) (*weather.WeatherData, error) {
+	key := coords.Key(wS.cellPrecision)
+
+	if cached, ok := wS.cellCache.Get(key); ok && time.Since(cached.fetchedAt) < wS.maxAge {
+		return cached.data, nil
+	}
+
+	val, err, _ := wS.sfG.Do(key, func() (any, error) {
+		// the flight answers every caller of the cell, the first one leaving must not cancel it
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), weatherFetchTimeout)
+		defer cancel()
+		return wS.owClient.GetWeatherDataApi(fetchCtx, coords.CellCenter(wS.cellPrecision))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := val.(*weather.WeatherData)
+	wS.cellCache.Add(key, cachedWeather{data: result, fetchedAt: time.Now()})
+	return result, nil
 }
 
 /*func (wS *WeatherService) GetWeatherData() (*WeatherData, error) {
@@ -62,12 +103,18 @@ func (wS *WeatherService) fetchAndQueue(addrs *FullAdress) (*WeatherData, error)
 	return data, nil
 }*/
 
-func NewWeatherService(db *database.Database, cacheSize int) (*WeatherService, error) {
+func NewWeatherService(db *database.Database, cacheSize int, owClient *api.OpenWeatherClient, cellPrecision int, maxAge time.Duration) (*WeatherService, error) {
 	c, _ := lru.New[uint, *weather.WeatherData](cacheSize)
+	cells, _ := lru.New[string, cachedWeather](cacheSize)
 
 	return &WeatherService{
 		Database:  db,
 		cache:     c,
 		saveQueue: make(chan *WeatherServicePayload, 100),
+		owClient:  owClient,
+
+		cellCache:     cells,
+		cellPrecision: cellPrecision,
+		maxAge:        maxAge,
 	}, nil
 }
@@ -0,0 +1,315 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type CyrillicScheme int
+
+const (
+	CyrillicBGN  CyrillicScheme = iota // BGN/PCGN, what most gazetteers use
+	CyrillicICAO                       // ICAO Doc 9303, as printed in passports
+)
+
+type GreekScheme int
+
+const (
+	GreekELOT      GreekScheme = iota // ELOT 743, modern pronunciation
+	GreekClassical                    // letter by letter, "ph" and "e" for eta
+)
+
+// Romanization picks how CleanQuery spells Cyrillic and Greek in Latin letters.
+// Stored names and queries must agree, so change it only together with the data.
+type Romanization struct {
+	Cyrillic CyrillicScheme
+	Greek    GreekScheme
+}
+
+var romanization atomic.Pointer[Romanization]
+
+func init() {
+	romanization.Store(&Romanization{})
+}
+
+// SetRomanization changes the scheme CleanQuery uses, call it before serving.
+func SetRomanization(r Romanization) {
+	romanization.Store(&r)
+}
+
+// ParseRomanization reads scheme names such as "bgn" and "classical", empty keeps the default.
+func ParseRomanization(cyrillic, greek string) (Romanization, error) {
+	var r Romanization
+
+	switch strings.ToLower(cyrillic) {
+	case "", "bgn":
+		r.Cyrillic = CyrillicBGN
+	case "icao":
+		r.Cyrillic = CyrillicICAO
+	default:
+		return r, fmt.Errorf("unknown Cyrillic romanization %q, expected bgn or icao", cyrillic)
+	}
+
+	switch strings.ToLower(greek) {
+	case "", "elot":
+		r.Greek = GreekELOT
+	case "classical":
+		r.Greek = GreekClassical
+	default:
+		return r, fmt.Errorf("unknown Greek romanization %q, expected elot or classical", greek)
+	}
+	return r, nil
+}
+
+// latinASCII folds the Latin letters that carry no combining mark, the part of
+// ICU's Latin-ASCII that NFD alone cannot do. Keys are lowercase.
+var latinASCII = map[rune]string{
+	'æ': "ae", 'ð': "d", 'ø': "o", 'þ': "th", 'ß': "ss", 'đ': "d", 'ħ': "h",
+	'ı': "i", 'ĸ': "q", 'ł': "l", 'ŋ': "n", 'œ': "oe", 'ŧ': "t", 'ſ': "s",
+	'ƀ': "b", 'ƈ': "c", 'ƒ': "f", 'ƙ': "k", 'ƚ': "l", 'ƞ': "n", 'ƥ': "p",
+	'ƫ': "t", 'ƭ': "t", 'ƴ': "y", 'ƶ': "z", 'ǝ': "e", 'ǥ': "g", 'ȡ': "d",
+	'ȴ': "l", 'ȵ': "n", 'ȶ': "t", 'ȷ': "j", 'ȼ': "c", 'ȿ': "s", 'ɀ': "z",
+	'ɇ': "e", 'ɉ': "j", 'ɍ': "r", 'ɏ': "y", 'ɐ': "a", 'ɓ': "b", 'ɔ': "o",
+	'ɕ': "c", 'ɖ': "d", 'ɗ': "d", 'ə': "e", 'ɛ': "e", 'ɟ': "j", 'ɠ': "g",
+	'ɡ': "g", 'ɦ': "h", 'ɨ': "i", 'ɫ': "l", 'ɬ': "l", 'ɭ': "l", 'ɱ': "m",
+	'ɲ': "n", 'ɳ': "n", 'ɵ': "o", 'ɽ': "r", 'ɾ': "r", 'ʂ': "s", 'ʈ': "t",
+	'ʉ': "u", 'ʋ': "v", 'ʐ': "z", 'ʑ': "z", 'ʒ': "z", 'ʝ': "j", 'ʠ': "q",
+	'ⱥ': "a", 'ⱦ': "t", 'ɂ': "",
+
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '‹': "'", '›': "'",
+	'“': "\"", '”': "\"", '„': "\"", '″': "\"", '«': "\"", '»': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-",
+}
+
+var cyrillicBGN = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "w",
+	'ђ': "dj", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+}
+
+var cyrillicICAO = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "iu", 'я': "ia",
+	'і': "i", 'ї': "i", 'є': "ie", 'ґ': "g", 'ў': "u",
+	'ђ': "d", 'ј': "j", 'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	'ѓ': "g", 'ќ': "k", 'ѕ': "dz",
+}
+
+// ukrainianIotated is what follows the y or i of є, ї, й, ю and я.
+var ukrainianIotated = map[rune]string{'є': "e", 'ї': "", 'й': "", 'ю': "u", 'я': "a"}
+
+var greekELOT = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'τ': "t", 'υ': "y", 'φ': "f",
+	'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+var greekClassical = map[rune]string{
+	'α': "a", 'β': "b", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "e",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'τ': "t", 'υ': "y", 'φ': "ph",
+	'χ': "ch", 'ψ': "ps", 'ω': "o",
+}
+
+// Transliterate lowercases s and spells Cyrillic, Greek and the special Latin
+// letters in plain Latin. Other scripts, CJK included, pass through unchanged.
+func Transliterate(s string, r Romanization) string {
+	// invalid bytes stop the normalizer from folding what follows them, ϖ to π
+	s = strings.ToLower(norm.NFKC.String(strings.ToValidUTF8(s, "\uFFFD")))
+	if isASCII(s) {
+		return s
+	}
+
+	rs := []rune(s)
+	var b strings.Builder
+	b.Grow(len(s))
+
+	// Ukrainian and Belarusian read г as h and и as y
+	ukrainian := strings.ContainsAny(s, "іїєґў")
+
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c < utf8.RuneSelf:
+			b.WriteRune(c)
+		case unicode.Is(unicode.Cyrillic, c):
+			writeCyrillic(&b, rs, i, r.Cyrillic, ukrainian)
+		case unicode.Is(unicode.Greek, c):
+			i += writeGreek(&b, rs, i, r.Greek)
+		default:
+			if rep, ok := latinASCII[c]; ok {
+				b.WriteString(rep)
+			} else if rep, ok := latinASCII[baseLetter(c)]; ok {
+				b.WriteString(rep) // ǽ, ǿ
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// baseLetter drops the combining marks of a precomposed letter.
+func baseLetter(c rune) rune {
+	base, _ := utf8.DecodeRuneInString(norm.NFD.String(string(c)))
+	return base
+}
+
+func isCyrillicVowel(c rune) bool {
+	return strings.ContainsRune("аеёиоуыэюяіїєйъь", c)
+}
+
+func writeCyrillic(b *strings.Builder, rs []rune, i int, scheme CyrillicScheme, ukrainian bool) {
+	table := cyrillicBGN
+	if scheme == CyrillicICAO {
+		table = cyrillicICAO
+	}
+
+	c := rs[i]
+	if _, ok := table[c]; !ok {
+		// accented letters such as ѐ read as their base letter
+		c = baseLetter(c)
+	}
+
+	wordStart := i == 0 || !unicode.IsLetter(rs[i-1])
+
+	if ukrainian {
+		switch c {
+		case 'г':
+			b.WriteString("h")
+			return
+		case 'и':
+			b.WriteString("y")
+			return
+		}
+		// BGN follows the Ukrainian national system, y at the start of a word and i inside it, Kyiv
+		if scheme == CyrillicBGN {
+			if rep, ok := ukrainianIotated[c]; ok {
+				if wordStart {
+					b.WriteString("y" + rep)
+				} else {
+					b.WriteString("i" + rep)
+				}
+				return
+			}
+		}
+	}
+
+	// BGN writes е and ё as ye at the start of a word and after a vowel, Yekaterinburg
+	if scheme == CyrillicBGN && (c == 'е' || c == 'ё') && !ukrainian {
+		if wordStart || isCyrillicVowel(rs[i-1]) {
+			b.WriteString("ye")
+			return
+		}
+	}
+
+	if rep, ok := table[c]; ok {
+		b.WriteString(rep)
+	} else {
+		b.WriteRune(c)
+	}
+}
+
+// greekLetter strips tonos and breathings, diaeresis tells a vowel that must
+// not join the one before it into a digraph.
+func greekLetter(c rune) (base rune, diaeresis bool) {
+	if c == 'ς' {
+		return 'σ', false
+	}
+	d := norm.NFD.String(string(c))
+	base, _ = utf8.DecodeRuneInString(d)
+	return base, strings.ContainsRune(d, '\u0308')
+}
+
+func isGreekVoiceless(c rune) bool {
+	return strings.ContainsRune("θκξπστφχψ", c)
+}
+
+// writeGreek writes the letter at i and returns how many extra runes it consumed.
+func writeGreek(b *strings.Builder, rs []rune, i int, scheme GreekScheme) int {
+	c, _ := greekLetter(rs[i])
+
+	var next rune = -1
+	if i+1 < len(rs) && unicode.Is(unicode.Greek, rs[i+1]) {
+		n, diaeresis := greekLetter(rs[i+1])
+		if !diaeresis {
+			next = n
+		}
+	}
+	wordStart := i == 0 || !unicode.IsLetter(rs[i-1])
+
+	switch {
+	case c == 'ο' && next == 'υ':
+		b.WriteString("ou")
+		return 1
+	case (c == 'α' || c == 'ε' || c == 'η') && next == 'υ':
+		if scheme == GreekClassical {
+			b.WriteString(greekClassical[c] + "u")
+			return 1
+		}
+		b.WriteString(greekELOT[c])
+		// αυ is af before a voiceless consonant or at the end, av otherwise
+		after := rune(-1)
+		if i+2 < len(rs) {
+			after, _ = greekLetter(rs[i+2])
+		}
+		if after == -1 || !unicode.IsLetter(after) || isGreekVoiceless(after) {
+			b.WriteString("f")
+		} else {
+			b.WriteString("v")
+		}
+		return 1
+	case c == 'γ' && (next == 'γ' || next == 'ξ' || next == 'χ'):
+		b.WriteString("n")
+		return 0
+	case c == 'γ' && next == 'κ':
+		if scheme == GreekELOT && wordStart {
+			b.WriteString("g")
+		} else if scheme == GreekELOT {
+			b.WriteString("ng")
+		} else {
+			b.WriteString("nk")
+		}
+		return 1
+	case scheme == GreekELOT && wordStart && c == 'μ' && next == 'π':
+		b.WriteString("b")
+		return 1
+	case scheme == GreekELOT && wordStart && c == 'ν' && next == 'τ':
+		b.WriteString("d")
+		return 1
+	}
+
+	table := greekELOT
+	if scheme == GreekClassical {
+		table = greekClassical
+	}
+	if rep, ok := table[c]; ok {
+		b.WriteString(rep)
+	} else {
+		b.WriteRune(rs[i])
+	}
+	return 0
+}
@@ -1,7 +1,11 @@
 package util
 
 import (
+	"bytes"
+	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -12,6 +16,30 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
+func GetEnvFloat(key string, fallback float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func GetEnvInt(key string, fallback int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
 func PingGoogle() (string, error) {
 	start := time.Now()
 
@@ -25,18 +53,35 @@ func PingGoogle() (string, error) {
 }
 
 func SendJson(w http.ResponseWriter, code int, payload any) {
-	w.Header().Set("Content-Type", "application/json")
+	SendJsonAs(w, code, "application/json", payload)
+}
 
-	err := sonic.ConfigDefault.NewEncoder(w).Encode(payload)
-	if err != nil {
+// SendJsonAs encodes payload before anything is written, so a payload that
+// fails to encode becomes a 500 instead of a truncated body under code.
+func SendJsonAs(w http.ResponseWriter, code int, contentType string, payload any) {
+	var buf bytes.Buffer
+	if err := sonic.ConfigDefault.NewEncoder(&buf).Encode(payload); err != nil {
 		SendErrorJson(w, "Failed to encode JSON", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
 }
 
 type apiError struct {
-	Error   string `json:"error"`
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Error   string       `json:"error"`
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError names a request parameter that was rejected and why.
+type FieldError struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
 }
 
 func SendErrorJson(w http.ResponseWriter, message string, code int) {
@@ -47,16 +92,63 @@ func SendErrorJson(w http.ResponseWriter, message string, code int) {
 	})
 }
 
+// SendFieldErrorJson is SendErrorJson listing the parameters at fault.
+func SendFieldErrorJson(w http.ResponseWriter, message string, code int, fields ...FieldError) {
+	SendJson(w, code, apiError{
+		Error:   http.StatusText(code),
+		Code:    code,
+		Message: message,
+		Fields:  fields,
+	})
+}
+
+// ParseGenericQuery zips comma separated query values into rows, the row count
+// is that of the shortest list. A value may be wrapped in double quotes, CSV
+// style with "" for a quote, to hold commas, and a backslash escapes the
+// character after it anywhere. An empty query is an omitted list, it adds ""
+// to every row instead of cutting the rows to zero.
 func ParseGenericQuery[T any](mapper func([]string) T, queries ...string) []T {
+	results, _ := parseGenericQuery(mapper, false, queries)
+	return results
+}
+
+// ParseGenericQueryStrict is ParseGenericQuery returning a *ColumnLengthError
+// instead of dropping the values of longer lists.
+func ParseGenericQueryStrict[T any](mapper func([]string) T, queries ...string) ([]T, error) {
+	return parseGenericQuery(mapper, true, queries)
+}
+
+// ColumnLengthError reports query lists with different numbers of values,
+// Lengths is aligned with the queries and 0 marks an omitted one.
+type ColumnLengthError struct {
+	Lengths []int
+}
+
+func (e *ColumnLengthError) Error() string {
+	return fmt.Sprintf("query lists differ in length: %v", e.Lengths)
+}
+
+func parseGenericQuery[T any](mapper func([]string) T, strict bool, queries []string) ([]T, error) {
 	if len(queries) == 0 {
-		return nil
+		return nil, nil
 	}
 
+	lengths := make([]int, len(queries))
 	minLen := -1
-	for _, q := range queries {
-		count := 1 + strings.Count(q, ",")
-		if minLen == -1 || count < minLen {
-			minLen = count
+	for j, q := range queries {
+		lengths[j] = countValues(q)
+		if lengths[j] > 0 && (minLen == -1 || lengths[j] < minLen) {
+			minLen = lengths[j]
+		}
+	}
+	if minLen == -1 {
+		return nil, nil
+	}
+	if strict {
+		for _, n := range lengths {
+			if n > 0 && n != minLen {
+				return nil, &ColumnLengthError{Lengths: lengths}
+			}
 		}
 	}
 
@@ -67,24 +159,91 @@ func ParseGenericQuery[T any](mapper func([]string) T, queries ...string) []T {
 
 	for i := 0; i < minLen; i++ {
 		for j, q := range queries {
-			remainder := q[starts[j]:]
-			commaIdx := strings.IndexByte(remainder, ',')
-
-			var part string
-			if commaIdx == -1 {
-				part = remainder
-				starts[j] = len(q)
-			} else {
-				part = remainder[:commaIdx]
-				starts[j] += commaIdx + 1
+			if lengths[j] == 0 {
+				rowBuffer[j] = ""
+				continue
 			}
-
-			rowBuffer[j] = strings.TrimSpace(part)
+			rowBuffer[j], starts[j] = nextValue(q, starts[j])
 		}
 		results = append(results, mapper(rowBuffer))
 	}
 
-	return results
+	return results, nil
+}
+
+func countValues(q string) int {
+	if q == "" {
+		return 0
+	}
+	n := 0
+	for i := 0; i <= len(q); n++ {
+		_, i = nextValue(q, i)
+	}
+	return n
+}
+
+// nextValue reads the value starting at q[i] and returns it with the index
+// after its comma, or len(q)+1 once q is used up.
+func nextValue(q string, i int) (string, int) {
+	for i < len(q) && (q[i] == ' ' || q[i] == '\t') {
+		i++
+	}
+	if i >= len(q) || q[i] != '"' {
+		return unquotedValue(q, i)
+	}
+
+	var b strings.Builder
+	for i++; i < len(q); i++ {
+		c := q[i]
+		if c == '\\' && i+1 < len(q) {
+			i++
+			b.WriteByte(q[i])
+			continue
+		}
+		if c == '"' {
+			if i+1 < len(q) && q[i+1] == '"' {
+				i++
+				b.WriteByte('"')
+				continue
+			}
+			i++
+			break
+		}
+		b.WriteByte(c)
+	}
+
+	// text between the closing quote and the comma is kept as it is
+	tail, next := unquotedValue(q, i)
+	b.WriteString(tail)
+	return b.String(), next
+}
+
+func unquotedValue(q string, i int) (string, int) {
+	start, escaped := i, false
+	for ; i < len(q) && q[i] != ','; i++ {
+		if q[i] == '\\' && i+1 < len(q) {
+			escaped = true
+			i++
+		}
+	}
+
+	value := strings.TrimSpace(q[start:i])
+	if escaped {
+		value = unescape(value)
+	}
+	return value, i + 1
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
 }
 
 func FilterNil[T any](data []*T) []*T {
@@ -99,11 +258,17 @@ func FilterNil[T any](data []*T) []*T {
 }
 
 func CleanQuery(input string) string {
+	return CleanQueryWith(input, *romanization.Load())
+}
+
+// CleanQueryWith is CleanQuery with an explicit romanization, for tables that
+// must not change when SetRomanization does.
+func CleanQueryWith(input string, r Romanization) string {
 	words := strings.Fields(input)
-	s := strings.Join(words, " ")
+	s := Transliterate(strings.Join(words, " "), r)
 
 	t := transform.Chain(
-		norm.NFD,
+		norm.NFKD,
 		runes.Remove(runes.In(unicode.Mn)),
 		norm.NFC,
 	)
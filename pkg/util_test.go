@@ -1,10 +1,14 @@
 package util
 
 import (
+	"errors"
 	"net/http/httptest"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"unicode"
 )
 
 // 1. Table-Driven Unit Test for ParseGenericQuery
@@ -60,15 +64,92 @@ func TestParseGenericQuery(t *testing.T) {
 	}
 }
 
+// Quoted values keep their commas, backslashes escape single characters.
+func TestParseGenericQueryQuoting(t *testing.T) {
+	tests := []struct {
+		name     string
+		cities   string
+		states   string
+		expected [][]string
+	}{
+		{"Plain", "Prague, Brno", "-,-", [][]string{{"Prague", "-"}, {"Brno", "-"}}},
+		{"Quoted Comma", `"Washington, D.C.",Paris`, "-,-", [][]string{{"Washington, D.C.", "-"}, {"Paris", "-"}}},
+		{"Doubled Quote", `"The ""Big"" Apple"`, "NY", [][]string{{`The "Big" Apple`, "NY"}}},
+		{"Quoted Spaces Kept", `" Brno "`, "-", [][]string{{" Brno ", "-"}}},
+		{"Escaped Comma", `Washington\, D.C.,Paris`, "-,-", [][]string{{"Washington, D.C.", "-"}, {"Paris", "-"}}},
+		{"Escaped Quote And Backslash", `"a\"b",c\\d`, "-,-", [][]string{{`a"b`, "-"}, {`c\d`, "-"}}},
+		{"Unterminated Quote", `"Prague,Brno`, "-", [][]string{{"Prague,Brno", "-"}}},
+		{"Empty Values", `,""`, "-,-", [][]string{{"", "-"}, {"", "-"}}},
+		{"Omitted Column", "Prague,Brno", "", [][]string{{"Prague", ""}, {"Brno", ""}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseGenericQuery(func(row []string) []string {
+				return slices.Clone(row)
+			}, tt.cities, tt.states)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseGenericQuery(%q, %q) = %q, want %q", tt.cities, tt.states, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseGenericQueryStrict(t *testing.T) {
+	clone := func(row []string) []string { return slices.Clone(row) }
+
+	got, err := ParseGenericQueryStrict(clone, `"Washington, D.C.",Paris`, "", "US,FR")
+	if err != nil {
+		t.Fatalf("ParseGenericQueryStrict() error = %v", err)
+	}
+	want := [][]string{{"Washington, D.C.", "", "US"}, {"Paris", "", "FR"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseGenericQueryStrict() = %q, want %q", got, want)
+	}
+
+	_, err = ParseGenericQueryStrict(clone, "Prague,Brno", "", "CZ")
+	var lenErr *ColumnLengthError
+	if !errors.As(err, &lenErr) {
+		t.Fatalf("ParseGenericQueryStrict() error = %v, want a *ColumnLengthError", err)
+	}
+	if !reflect.DeepEqual(lenErr.Lengths, []int{2, 0, 1}) {
+		t.Errorf("ColumnLengthError.Lengths = %v, want [2 0 1]", lenErr.Lengths)
+	}
+}
+
 // 2. Fuzz Test: The "Chaos Monkey"
 // This will try to crash your parser with random characters, emojis, and null bytes.
+// Any value quoted CSV style must also come back unchanged.
 func FuzzParseGenericQuery(f *testing.F) {
 	f.Add("10.0,20.0", "30.0,40.0")
+	f.Add(`"Washington, D.C.",Paris`, `-,"Île-de-France"`)
+	f.Add(`a\,b,"c""d"`, `"unterminated,\`)
+
+	quote := func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
 
 	f.Fuzz(func(t *testing.T, a string, b string) {
 		ParseGenericQuery(func(row []string) int {
 			return len(row)
 		}, a, b)
+
+		if _, err := ParseGenericQueryStrict(func(row []string) int {
+			return len(row)
+		}, a, b); err != nil {
+			var lenErr *ColumnLengthError
+			if !errors.As(err, &lenErr) {
+				t.Errorf("ParseGenericQueryStrict(%q, %q) error = %v, want a *ColumnLengthError", a, b, err)
+			}
+		}
+
+		got := ParseGenericQuery(func(row []string) string {
+			return row[0]
+		}, quote(a)+","+quote(b))
+		if want := []string{a, b}; !reflect.DeepEqual(got, want) {
+			t.Errorf("quoted values %q, %q came back as %q", a, b, got)
+		}
 	})
 }
 
@@ -105,3 +186,98 @@ func TestSendJson(t *testing.T) {
 		t.Errorf("Expected content type application/json, got %s", contentType)
 	}
 }
+
+func TestSendJsonEncodeFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	SendJson(w, 200, map[string]any{"bad": func() {}})
+
+	if w.Code != 500 {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Failed to encode JSON") {
+		t.Errorf("Expected only the error body, got %s", w.Body.String())
+	}
+}
+
+// 5. Table-Driven Test for CleanQuery transliteration
+// Stored names and typed queries must land on the same ASCII form.
+func TestCleanQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Accents", "  Frýdek   Místek ", "frydek-mistek"},
+		{"Polish Stroke", "Łódź", "lodz"},
+		{"Danish O", "Ærøskøbing", "aeroskobing"},
+		{"German Sharp S", "Gießen", "giessen"},
+		{"Croatian D", "Đakovo", "dakovo"},
+		{"Typographic Apostrophe", "L’Aquila", "l'aquila"},
+		{"Russian", "Москва", "moskva"},
+		{"Russian Initial Ye", "Екатеринбург", "yekaterinburg"},
+		{"Russian Soft Sign", "Пермь", "perm"},
+		{"Ukrainian", "Київ", "kyiv"},
+		{"Ukrainian H", "Харків", "kharkiv"},
+		{"Ukrainian Ia", "Запоріжжя", "zaporizhzhia"},
+		{"Serbian", "Београд", "beograd"},
+		{"Greek", "Αθήνα", "athina"},
+		{"Greek Digraphs", "Θεσσαλονίκη", "thessaloniki"},
+		{"Greek Af", "Ναύπλιο", "nafplio"},
+		{"Greek Initial Mp", "Μπάρι", "bari"},
+		{"Fullwidth", "ＴＯＫＹＯ", "tokyo"},
+		{"CJK Passes Through", "東京", "東京"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanQuery(tt.input); got != tt.expected {
+				t.Errorf("CleanQuery(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCleanQueryWithRomanization(t *testing.T) {
+	tests := []struct {
+		name         string
+		romanization Romanization
+		input        string
+		expected     string
+	}{
+		{"BGN", Romanization{Cyrillic: CyrillicBGN}, "Ярославль", "yaroslavl"},
+		{"ICAO", Romanization{Cyrillic: CyrillicICAO}, "Ярославль", "iaroslavl"},
+		{"ICAO Hard Sign", Romanization{Cyrillic: CyrillicICAO}, "Подъячево", "podieiachevo"},
+		{"ELOT", Romanization{Greek: GreekELOT}, "Φιλιππούπολη", "filippoupoli"},
+		{"Classical", Romanization{Greek: GreekClassical}, "Φιλιππούπολη", "philippoupole"},
+		{"Classical Eu", Romanization{Greek: GreekClassical}, "Εύβοια", "euboia"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanQueryWith(tt.input, tt.romanization); got != tt.expected {
+				t.Errorf("CleanQueryWith(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// 6. Fuzz Test for CleanQuery
+// Cleaning twice must change nothing, otherwise stored names and queries drift apart.
+func FuzzCleanQuery(f *testing.F) {
+	f.Add("Frýdek-Místek")
+	f.Add("Łódź Ærø Gießen")
+	f.Add("Екатеринбург Київ")
+	f.Add("Ναύπλιο Μπάρι")
+	f.Add("\xf4ϖ")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		once := CleanQuery(input)
+		if twice := CleanQuery(once); twice != once {
+			t.Errorf("CleanQuery is not idempotent: %q -> %q -> %q", input, once, twice)
+		}
+		if strings.ContainsFunc(once, unicode.IsSpace) {
+			t.Errorf("CleanQuery(%q) = %q still has whitespace", input, once)
+		}
+	})
+}